@@ -0,0 +1,182 @@
+package gosocket
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+const (
+	defaultBreakerThreshold   = 5
+	defaultBreakerOpenTimeout = 10 * time.Second
+)
+
+// ErrCircuitOpen is returned when the circuit breakers of all servers are open.
+var ErrCircuitOpen = errors.New("the circuit breaker is open")
+
+// BreakerState is the state of a circuit breaker.
+type BreakerState int
+
+const (
+	// BreakerClosed lets all requests go through.
+	BreakerClosed BreakerState = iota
+	// BreakerOpen rejects all requests until the open timeout passes.
+	BreakerOpen
+	// BreakerHalfOpen lets a probing request go through, the breaker is closed
+	// if it succeeds, or opened again if it fails.
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+type BreakerOpts struct {
+	// threshold is the number of the consecutive failures to open the breaker.
+	// It's default value is 5.
+	threshold int
+	// openTimeout is the duration the breaker keeps open before probing.
+	// It's default value is "10*time.second".
+	openTimeout time.Duration
+	// onStateChange is the callback when the state of the breaker of a server changes.
+	onStateChange func(addr string, from, to BreakerState)
+}
+
+// BreakerOpt specifies an option for the circuit breakers.
+type BreakerOpt func(*BreakerOpts)
+
+// BreakerThreshold returns a BreakerOpt to set the number of the consecutive failures
+// to open the breaker.
+func BreakerThreshold(n int) BreakerOpt {
+	return func(o *BreakerOpts) {
+		o.threshold = n
+	}
+}
+
+// BreakerOpenTimeout returns a BreakerOpt to set the duration the breaker keeps open
+// before probing the server.
+func BreakerOpenTimeout(t time.Duration) BreakerOpt {
+	return func(o *BreakerOpts) {
+		o.openTimeout = t
+	}
+}
+
+// OnBreakerStateChange returns a BreakerOpt to set the callback when the state
+// of the breaker of a server changes.
+func OnBreakerStateChange(onStateChange func(addr string, from, to BreakerState)) BreakerOpt {
+	return func(o *BreakerOpts) {
+		o.onStateChange = onStateChange
+	}
+}
+
+// breaker is the circuit breaker of a server.
+type breaker struct {
+	addr string
+	opts *BreakerOpts
+
+	mu       sync.Mutex
+	state    BreakerState
+	failures int
+	openedAt time.Time
+	// probing indicates whether a probing request is in flight in the half-open state.
+	probing bool
+	probeAt time.Time
+}
+
+func newBreaker(addr string, opts *BreakerOpts) *breaker {
+	return &breaker{addr: addr, opts: opts}
+}
+
+// allow reports whether a request can go through.
+// A probing request is assumed lost if no result is reported in the open timeout.
+func (b *breaker) allow() bool {
+	b.mu.Lock()
+	now := time.Now()
+	from := b.state
+	switch b.state {
+	case BreakerOpen:
+		if now.Sub(b.openedAt) < b.opts.openTimeout {
+			b.mu.Unlock()
+			return false
+		}
+		b.state = BreakerHalfOpen
+	case BreakerHalfOpen:
+		if b.probing && now.Sub(b.probeAt) < b.opts.openTimeout {
+			b.mu.Unlock()
+			return false
+		}
+	default:
+		b.mu.Unlock()
+		return true
+	}
+	b.probing, b.probeAt = true, now
+	to := b.state
+	b.mu.Unlock()
+	b.notify(from, to)
+	return true
+}
+
+// done records the result of a request.
+func (b *breaker) done(ok bool) {
+	b.mu.Lock()
+	from := b.state
+	switch b.state {
+	case BreakerClosed:
+		if ok {
+			b.failures = 0
+			break
+		}
+		b.failures++
+		if b.failures >= b.opts.threshold {
+			b.state, b.openedAt = BreakerOpen, time.Now()
+		}
+	case BreakerHalfOpen:
+		b.probing = false
+		if ok {
+			b.state, b.failures = BreakerClosed, 0
+		} else {
+			b.state, b.openedAt = BreakerOpen, time.Now()
+		}
+	case BreakerOpen:
+		// The results of the requests sent before opening are ignored.
+	}
+	to := b.state
+	b.mu.Unlock()
+	b.notify(from, to)
+}
+
+// report records the result of a request by the b, it does nothing if the b is nil.
+// A request cancelled by the caller or failed locally has no result, and only
+// releases the probing request.
+func (b *breaker) report(err error) {
+	switch {
+	case b == nil:
+	case errors.Is(err, context.Canceled), errors.Is(err, ErrTooManyRequests),
+		errors.Is(err, ErrNoCredit), errors.Is(err, ErrStreamClosed):
+		b.release()
+	default:
+		b.done(!isFailure(err))
+	}
+}
+
+// release releases the probing request without a result.
+func (b *breaker) release() {
+	b.mu.Lock()
+	b.probing = false
+	b.mu.Unlock()
+}
+
+func (b *breaker) notify(from, to BreakerState) {
+	if from != to && b.opts.onStateChange != nil {
+		b.opts.onStateChange(b.addr, from, to)
+	}
+}
@@ -0,0 +1,125 @@
+package gosocket
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/happyxcj/gosocket/pkts"
+)
+
+func newTestBreaker(threshold int, openTimeout time.Duration) *breaker {
+	return newBreaker("test", &BreakerOpts{threshold: threshold, openTimeout: openTimeout})
+}
+
+func TestBreakerStates(t *testing.T) {
+	b := newTestBreaker(2, 20*time.Millisecond)
+	b.report(ErrTimeout)
+	b.report(nil)
+	b.report(ErrTimeout)
+	if b.state != BreakerClosed {
+		t.Fatalf("a success must reset the consecutive failures, got %v", b.state)
+	}
+	b.report(ErrTimeout)
+	if b.state != BreakerOpen || b.allow() {
+		t.Fatalf("expect open, got %v", b.state)
+	}
+	time.Sleep(30 * time.Millisecond)
+	if !b.allow() || b.state != BreakerHalfOpen {
+		t.Fatalf("expect a probe in the half-open state, got %v", b.state)
+	}
+	if b.allow() {
+		t.Fatal("only one probe is allowed")
+	}
+	b.report(ErrTimeout)
+	if b.state != BreakerOpen {
+		t.Fatalf("a failed probe must open the breaker, got %v", b.state)
+	}
+	time.Sleep(30 * time.Millisecond)
+	b.allow()
+	b.report(nil)
+	if b.state != BreakerClosed {
+		t.Fatalf("a successful probe must close the breaker, got %v", b.state)
+	}
+}
+
+func TestBreakerRelease(t *testing.T) {
+	b := newTestBreaker(1, 20*time.Millisecond)
+	b.report(ErrTimeout)
+	time.Sleep(30 * time.Millisecond)
+	b.allow()
+	// A request without a result releases the probe.
+	b.report(ErrStreamClosed)
+	if b.state != BreakerHalfOpen || !b.allow() {
+		t.Fatalf("the probe is not released, got %v", b.state)
+	}
+	var nilBreaker *breaker
+	nilBreaker.report(ErrTimeout)
+}
+
+type pipeDialer struct{}
+
+func (pipeDialer) Dial(network, address string) (net.Conn, error) {
+	c, _ := net.Pipe()
+	return c, nil
+}
+
+func newTestPool() *clientConnPool {
+	p := newClientConnPool(StaticResolver{"a"}, pipeDialer{}, func(nc net.Conn) Conn { return idleConn{} })
+	p.breakerOpts = &BreakerOpts{threshold: 1, openTimeout: 20 * time.Millisecond}
+	return p
+}
+
+func TestPoolGetConnKeepsProbe(t *testing.T) {
+	p := newTestPool()
+	_, b, err := p.get(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.report(ErrTimeout)
+	if _, _, err := p.get(true); err != ErrCircuitOpen {
+		t.Fatalf("expect %v, got %v", ErrCircuitOpen, err)
+	}
+	time.Sleep(30 * time.Millisecond)
+	// GetConn must not take the probe since it records no result.
+	if _, err := p.GetConn(); err != nil {
+		t.Fatal(err)
+	}
+	_, b, err = p.get(true)
+	if err != nil {
+		t.Fatalf("the probe is taken by GetConn: %v", err)
+	}
+	b.report(nil)
+	if b.state != BreakerClosed {
+		t.Fatalf("expect closed, got %v", b.state)
+	}
+}
+
+func TestPoolBreakerAfterGoAway(t *testing.T) {
+	p := newTestPool()
+	conn, b, err := p.get(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.goAway(conn, "")
+	b.report(ErrTimeout)
+	if b.state != BreakerOpen {
+		t.Fatalf("the result after going away is lost, got %v", b.state)
+	}
+}
+
+func TestSendNotSuccess(t *testing.T) {
+	c := NewClient("a")
+	c.pool = newTestPool()
+	c.pool.breakerOpts.threshold = 2
+	_, b, _ := c.pool.get(true)
+	b.report(ErrTimeout)
+	// A queued packet has no response, so it doesn't reset the failures.
+	if err := c.Send(pkts.NewPingPkt()); err != nil {
+		t.Fatal(err)
+	}
+	b.report(ErrTimeout)
+	if b.state != BreakerOpen {
+		t.Fatalf("expect open, got %v", b.state)
+	}
+}
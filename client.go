@@ -1,31 +1,85 @@
-
 package gosocket
 
 import (
-	"time"
+	"context"
 	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/happyxcj/gosocket/pkts"
 	"github.com/happyxcj/gosocket/protocol"
 )
 
 const (
-	defaultDialTimeout = 5 * time.Second
+	defaultDialTimeout       = 5 * time.Second
+	defaultRespTimeout       = 10 * time.Second
+	defaultReconnectInterval = time.Second
+	defaultStreamWindow      = 16
+	defaultResolveInterval   = 30 * time.Second
+	defaultFailCooldown      = 5 * time.Second
+	// drainPollInterval is the interval to check whether the in-flight requests
+	// of a connection going away finish.
+	drainPollInterval = 100 * time.Millisecond
 )
 
 // Client represents a client connection to an specified server.
 type Client struct {
 	// opts contains the options to dial a server.
-	opts      *DialOpts
-	pool      *clientConnPool
+	opts *DialOpts
+	pool *clientConnPool
+
+	// closedFlag indicates whether the client is closed.
+	closedFlag uint32
+
+	// autoSeqId is used to generate the sequence ids of the requests.
+	autoSeqId uint32
+	mu        sync.Mutex
+	// calls contains the in-flight requests keyed by the sequence id.
+	calls map[uint16]*call
+
+	subs *SubManager
+
+	// muxes contains the stream multiplexers of the connections.
+	muxes map[Conn]*Mux
 }
 
 type DialOpts struct {
 	ecOpts      []EasyConnOpt
 	qcOpts      []QueueConnOpt
+	muxOpts     []MuxOpt
 	dialTimeout time.Duration
 	// respTimeout specifies the timeout to wait for a server's response.
 	// It's default value is "10*time.second".
-	//respTimeout time.Duration
-	dialer      MyDialer
+	respTimeout time.Duration
+	// reconnectInterval specifies the interval between the attempts to reconnect
+	// to the server for the subscriptions.
+	// It's default value is "1*time.second".
+	reconnectInterval time.Duration
+	// streamWindow specifies the number of the responses of a stream that can be
+	// sent by the server before they are received by the application.
+	// It's default value is 16.
+	streamWindow uint32
+	dialer       MyDialer
+	// resolver resolves the addresses of the servers, it overrides
+	// the addresses passed to NewClient.
+	resolver Resolver
+	// resolveInterval specifies the interval to re-resolve the addresses,
+	// it's not applied to a StaticResolver.
+	// It's default value is "30*time.second".
+	resolveInterval time.Duration
+	// failCooldown specifies the duration an address is not used for after
+	// failing to connect to it.
+	// It's default value is "5*time.second".
+	failCooldown time.Duration
+	// retry is the retry policy of the failed requests, it's disabled if nil.
+	retry *RetryOpts
+	// breaker contains the options of the circuit breakers of the servers,
+	// they are disabled if nil.
+	breaker *BreakerOpts
+	// router routes the received packets that are neither responses nor
+	// published packets of the subscriptions.
+	router Router
 }
 
 // DialOpt specifies an option for a connection.
@@ -45,10 +99,101 @@ func QCOptions(opts ...QueueConnOpt) DialOpt {
 	}
 }
 
+// MuxOptions returns a DialOpt to add options to the internal muxOpts.
+func MuxOptions(opts ...MuxOpt) DialOpt {
+	return func(o *DialOpts) {
+		o.muxOpts = append(o.muxOpts, opts...)
+	}
+}
+
 // RespTimeout returns a DialOpt to set the timeout to wait for a server's response.
 func RespTimeout(t time.Duration) DialOpt {
 	return func(o *DialOpts) {
-		o.dialTimeout = t
+		o.respTimeout = t
+	}
+}
+
+// ReconnectInterval returns a DialOpt to set the interval between the attempts
+// to reconnect to the server for the subscriptions.
+func ReconnectInterval(t time.Duration) DialOpt {
+	return func(o *DialOpts) {
+		o.reconnectInterval = t
+	}
+}
+
+// ClientRouter returns a DialOpt to set the router for the received packets
+// that are neither responses nor published packets of the subscriptions.
+// The packets that can't be handled by the router are passed to the packet handler
+// of the connection.
+//
+// The late responses of the requests given up, such as the timed out ones,
+// may be passed to the router if they carry no error status, so the router
+// must not reply to the unknown packets, e.g. by route.NotFound.
+func ClientRouter(router Router) DialOpt {
+	return func(o *DialOpts) {
+		o.router = router
+	}
+}
+
+// StreamWindow returns a DialOpt to set the window of the streams, see Client.Stream.
+func StreamWindow(n uint32) DialOpt {
+	return func(o *DialOpts) {
+		o.streamWindow = n
+	}
+}
+
+// ClientResolver returns a DialOpt to set the resolver of the addresses of the servers.
+func ClientResolver(r Resolver) DialOpt {
+	return func(o *DialOpts) {
+		o.resolver = r
+	}
+}
+
+// ResolveInterval returns a DialOpt to set the interval to re-resolve the addresses.
+func ResolveInterval(t time.Duration) DialOpt {
+	return func(o *DialOpts) {
+		o.resolveInterval = t
+	}
+}
+
+// FailCooldown returns a DialOpt to set the duration an address is not used for
+// after failing to connect to it.
+func FailCooldown(t time.Duration) DialOpt {
+	return func(o *DialOpts) {
+		o.failCooldown = t
+	}
+}
+
+// RetryOptions returns a DialOpt to enable retrying the failed requests
+// and add options to the retry policy.
+func RetryOptions(opts ...RetryOpt) DialOpt {
+	return func(o *DialOpts) {
+		if o.retry == nil {
+			o.retry = &RetryOpts{
+				maxAttempts: defaultRetryAttempts,
+				backoff:     ExponentialBackoff(defaultRetryBaseDelay, defaultRetryMaxDelay),
+			}
+		}
+		for _, opt := range opts {
+			opt(o.retry)
+		}
+	}
+}
+
+// BreakerOptions returns a DialOpt to enable a circuit breaker for every server
+// and add options to the circuit breakers. A server is not used while its breaker
+// is open, and ErrCircuitOpen is returned if the breakers of all servers are open.
+func BreakerOptions(opts ...BreakerOpt) DialOpt {
+	return func(o *DialOpts) {
+		if o.breaker == nil {
+			o.breaker = &BreakerOpts{
+				threshold:   defaultBreakerThreshold,
+				openTimeout: defaultBreakerOpenTimeout,
+			}
+		}
+		for _, opt := range opts {
+			opt(o.breaker)
+		}
 	}
 }
 
@@ -66,22 +211,70 @@ func Dialer(dialer MyDialer) DialOpt {
 	}
 }
 
+// NewClient returns a Client to the servers of the addr, which can be
+// a comma separated list of addresses, such as "host1:8080,host2:8080".
+// The requests are balanced across the servers that can be connected,
+// see ClientResolver for the dynamic addresses.
 func NewClient(addr string, opts ... DialOpt) *Client {
 	c := &Client{
+		calls: make(map[uint16]*call),
+		muxes: make(map[Conn]*Mux),
 	}
 	c.opts = &DialOpts{
-		dialTimeout: defaultDialTimeout,
-		dialer:      &net.Dialer{Timeout: defaultDialTimeout},
+		dialTimeout:       defaultDialTimeout,
+		respTimeout:       defaultRespTimeout,
+		reconnectInterval: defaultReconnectInterval,
+		streamWindow:      defaultStreamWindow,
+		resolveInterval:   defaultResolveInterval,
+		failCooldown:      defaultFailCooldown,
+		dialer:            &net.Dialer{Timeout: defaultDialTimeout},
 	}
 	for _, opt := range opts {
 		opt(c.opts)
 	}
+	c.subs = newSubManager(c)
 	connCreator := func(nc net.Conn) Conn {
 		inner := NewEasyConn(nc, c.opts.ecOpts...)
-		conn := NewQueueConn(inner, c.opts.qcOpts...)
+		conn := newQueueConn(inner, c.opts.qcOpts...)
+		m := NewMux(conn, true, c.opts.muxOpts...)
+		c.mu.Lock()
+		c.muxes[conn] = m
+		c.mu.Unlock()
+		pktHandler := conn.pktHandler
+		conn.pktHandler = func(p protocol.Packet) {
+			if m.HandlePacket(conn, p) || c.handlePkt(conn, p) {
+				return
+			}
+			if c.opts.router != nil && c.opts.router.HandlePacket(conn, p) {
+				return
+			}
+			pktHandler(p)
+		}
+		onClose := conn.onClose
+		conn.onClose = func(cause error) {
+			c.mu.Lock()
+			delete(c.muxes, conn)
+			c.mu.Unlock()
+			m.Close()
+			c.handleClose(conn)
+			if onClose != nil {
+				onClose(cause)
+			}
+		}
+		conn.start()
 		return conn
 	}
-	c.pool = newClientConnPool(addr, c.opts.dialer, connCreator)
+	resolver := c.opts.resolver
+	if resolver == nil {
+		resolver = ParseStaticResolver(addr)
+	}
+	c.pool = newClientConnPool(resolver, c.opts.dialer, connCreator)
+	c.pool.cooldown = c.opts.failCooldown
+	c.pool.breakerOpts = c.opts.breaker
+	c.pool.onRetire = c.retire
+	if _, static := resolver.(StaticResolver); !static && c.opts.resolveInterval > 0 {
+		go c.pool.watch(c.opts.resolveInterval)
+	}
 	return c
 }
 
@@ -95,16 +288,134 @@ func NewAndInitClient(addr string, opts ... DialOpt) (*Client, error) {
 	return c, nil
 }
 
+// Subs returns the subscription manager of the client.
+func (c *Client) Subs() *SubManager {
+	return c.subs
+}
+
+// Mux returns the stream multiplexer of the connection to the server,
+// the streams opened by the server are accepted by it.
+func (c *Client) Mux() (*Mux, error) {
+	conn, err := c.pool.GetConn()
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	m, ok := c.muxes[conn]
+	c.mu.Unlock()
+	if !ok {
+		return nil, ErrConnClosed
+	}
+	return m, nil
+}
+
 // Send sends a packet to the server.
+// It's retried by the retry policy if the p is not sent, see RetryOptions.
 func (c *Client) Send(p protocol.Packet) error {
-	conn, err := c.pool.GetConn()
+	ctx := context.Background()
+	for attempt := 1; ; attempt++ {
+		err := c.sendOnce(p)
+		if err == nil {
+			return nil
+		}
+		// The p is not in the sending queue if it fails.
+		retry := c.opts.retry
+		if retry == nil || !retry.shouldRetry(ctx, p, attempt, err, true) || !retry.wait(ctx, attempt) {
+			return err
+		}
+	}
+}
+
+func (c *Client) sendOnce(p protocol.Packet) error {
+	conn, b, err := c.pool.get(true)
 	if err != nil {
 		return err
 	}
-	return conn.Send(p)
+	if err = conn.Send(p); err != nil {
+		b.report(err)
+	} else if b != nil {
+		// The p is only queued without any response, it's not a result of the server.
+		b.release()
+	}
+	return err
 }
 
-// Close closes all idle connections in the pool.
+// Close closes all connections in the pool and stops re-resolving the addresses.
 func (c *Client) Close() error  {
+	atomic.StoreUint32(&c.closedFlag, 1)
 	return c.pool.Close()
 }
+
+// IsClosed returns a bool indicating whether the client has been closed.
+func (c *Client) IsClosed() bool {
+	return atomic.LoadUint32(&c.closedFlag) != 0
+}
+
+// handlePkt delivers the p received from the conn to the waiting request
+// or the subscriptions.
+// It returns false if the p is not handled.
+func (c *Client) handlePkt(conn Conn, p protocol.Packet) bool {
+	switch pkt := p.(type) {
+	case pkts.ReqRespPkt:
+		// A packet with a status is the late response of a request given up,
+		// drop it instead of handling it as a request.
+		return c.deliverResp(conn, pkt) || pkts.HasStatus(pkt)
+	case *pkts.PubPkt:
+		return c.subs.dispatch(pkt)
+	case *pkts.GoAwayPkt:
+		c.goAway(conn, pkt)
+		return true
+	}
+	return false
+}
+
+// handleClose fails all in-flight requests on the conn,
+// and starts re-issuing the subscriptions of it on another connection.
+func (c *Client) handleClose(conn Conn) {
+	c.failCalls(conn)
+	if !c.IsClosed() && c.subs.hasConn(conn) {
+		go c.reconnect(conn)
+	}
+}
+
+// goAway stops using the conn for new requests, they are sent by other connections
+// or a new connection to the redirect address of the p if any.
+func (c *Client) goAway(conn Conn, p *pkts.GoAwayPkt) {
+	c.pool.goAway(conn, p.Addr())
+	c.retire(conn)
+}
+
+// retire moves the subscriptions of the conn to another connection,
+// and closes the conn when its in-flight requests finish.
+func (c *Client) retire(conn Conn) {
+	go c.drain(conn)
+	if !c.IsClosed() && c.subs.hasConn(conn) {
+		go c.reconnect(conn)
+	}
+}
+
+// drain closes the conn when it has no in-flight request.
+func (c *Client) drain(conn Conn) {
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if qc, ok := conn.(*QueueConn); ok && qc.IsClosed() {
+			return
+		}
+		if !c.hasCalls(conn) {
+			conn.Close()
+			return
+		}
+	}
+}
+
+// reconnect tries to re-issue the subscriptions of the conn on another connection
+// until it succeeds or the client is closed.
+func (c *Client) reconnect(conn Conn) {
+	for !c.IsClosed() {
+		if err := c.subs.resubscribe(conn); err == nil {
+			return
+		}
+		time.Sleep(c.opts.reconnectInterval)
+	}
+}
@@ -0,0 +1,26 @@
+package gosocket
+
+import (
+	"context"
+
+	"github.com/happyxcj/gosocket/pkts"
+)
+
+// Call sends the req as a 'http' request with the cmd, version and codec,
+// and returns the decoded response synchronously.
+// It's the client side of the services registered by route.RegisterService.
+func Call[Req, Resp any](ctx context.Context, c *Client, cmd uint16, version, codec byte, req *Req) (*Resp, error) {
+	pkt := pkts.NewFullHttpPkt(0, cmd, version, codec, nil)
+	if err := pkts.EncodeBody(pkt, req); err != nil {
+		return nil, err
+	}
+	resp, err := c.Http(ctx, pkt)
+	if err != nil {
+		return nil, err
+	}
+	out := new(Resp)
+	if err = pkts.DecodeBody(resp, out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
@@ -1,9 +1,10 @@
 package gosocket
 
 import (
+	"math/rand"
 	"net"
-	"unsafe"
-	"sync/atomic"
+	"sync"
+	"time"
 )
 
 // ClientConnPool manages a pool of client connections.
@@ -14,12 +15,41 @@ type ClientConnPool interface {
 	Close() error
 }
 
+// clientConnPool keeps a connection to every endpoint resolved by the resolver,
+// and balances the requests across the healthy endpoints in turn.
+//
+// An endpoint is marked failed for the cool-down duration if dialing it fails.
+// If all endpoints are cooling down, the one whose cool-down ends first is tried.
 type clientConnPool struct {
-	addr         string
-	connCreator  func(nc net.Conn) Conn
-	connAddr     *unsafe.Pointer
-	dialer       MyDialer
-	dialCallAddr *unsafe.Pointer
+	resolver    Resolver
+	dialer      MyDialer
+	connCreator func(nc net.Conn) Conn
+	// onRetire is called with the connection of an endpoint removed by the
+	// re-resolving or a GoAwayPkt, it should be closed after its in-flight requests.
+	onRetire func(conn Conn)
+	cooldown time.Duration
+	// breakerOpts contains the options of the circuit breakers of the endpoints,
+	// they are disabled if nil.
+	breakerOpts *BreakerOpts
+
+	mu        sync.Mutex
+	closed    bool
+	endpoints []*endpoint
+	// next is the index of the endpoint to try first.
+	next int
+	// quit is closed to stop re-resolving when the pool is closed.
+	quit chan struct{}
+}
+
+// endpoint represents a server address and its connection.
+type endpoint struct {
+	addr    string
+	conn    Conn
+	dialing *dialCall
+	// failedUntil is the end of the cool-down after a dial failure.
+	failedUntil time.Time
+	// breaker is the circuit breaker of the endpoint, it's nil if disabled.
+	breaker *breaker
 }
 
 // MyDialer defines how to connect to the address on the named network.
@@ -36,75 +66,247 @@ type dialCall struct {
 	err error
 }
 
-func newClientConnPool(addr string, dialer MyDialer, connCreator func(nc net.Conn) Conn) *clientConnPool {
-	p := &clientConnPool{
-		addr:        addr,
-		connCreator: connCreator,
+func newClientConnPool(resolver Resolver, dialer MyDialer, connCreator func(nc net.Conn) Conn) *clientConnPool {
+	return &clientConnPool{
+		resolver:    resolver,
 		dialer:      dialer,
+		connCreator: connCreator,
+		onRetire:    func(conn Conn) { conn.Close() },
+		cooldown:    defaultFailCooldown,
+		quit:        make(chan struct{}),
+	}
+}
+
+// watch re-resolves the endpoints at the interval until the pool is closed.
+func (p *clientConnPool) watch(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			p.resolve()
+		case <-p.quit:
+			return
+		}
+	}
+}
+
+// resolve updates the endpoints by the addresses resolved by the resolver.
+// The endpoints are kept if the resolver fails.
+func (p *clientConnPool) resolve() error {
+	addrs, err := p.resolver.Resolve()
+	if err != nil {
+		return err
+	}
+	if len(addrs) == 0 {
+		return ErrNoAddress
+	}
+	p.mu.Lock()
+	old := make(map[string]*endpoint, len(p.endpoints))
+	for _, ep := range p.endpoints {
+		old[ep.addr] = ep
+	}
+	endpoints := make([]*endpoint, 0, len(addrs))
+	for _, addr := range addrs {
+		if ep, ok := old[addr]; ok {
+			endpoints = append(endpoints, ep)
+			delete(old, addr)
+			continue
+		}
+		endpoints = append(endpoints, p.newEndpoint(addr))
+	}
+	if len(p.endpoints) == 0 {
+		// Start from a random endpoint to spread the clients.
+		p.next = rand.Intn(len(endpoints))
 	}
-	var addr1 *byte
-	p.connAddr = (*unsafe.Pointer)(unsafe.Pointer(&addr1))
-	var addr2 *byte
-	p.dialCallAddr = (*unsafe.Pointer)(unsafe.Pointer(&addr2))
-	return p
+	p.endpoints = endpoints
+	var retired []Conn
+	for _, ep := range old {
+		if ep.conn != nil {
+			retired = append(retired, ep.conn)
+		}
+	}
+	p.mu.Unlock()
+	for _, conn := range retired {
+		p.onRetire(conn)
+	}
+	return nil
 }
 
+// GetConn returns a connection regardless of the circuit breakers, since no result
+// of a request is recorded for it, such as the connection of a Mux.
 func (p *clientConnPool) GetConn() (Conn, error) {
-	conn, ok := p.getConn()
-	if !ok {
-		return p.doDial()
+	conn, _, err := p.get(false)
+	return conn, err
+}
+
+// get returns a connection and the circuit breaker of its endpoint.
+//
+// If the guard is true, the endpoints whose breakers reject the request are skipped,
+// and the result of the request on the connection must be reported to the returned
+// breaker by its report method. The breaker is captured here, so the result is still
+// recorded after the connection is retired or goes away. It's nil if the guard is false
+// or the breaker is disabled.
+func (p *clientConnPool) get(guard bool) (Conn, *breaker, error) {
+	p.mu.Lock()
+	n := len(p.endpoints)
+	p.mu.Unlock()
+	if n == 0 {
+		if err := p.resolve(); err != nil {
+			return nil, nil, err
+		}
+	}
+	var err error
+	for _, ep := range p.candidates() {
+		b := ep.breaker
+		if !guard {
+			b = nil
+		}
+		if b != nil && !b.allow() {
+			err = ErrCircuitOpen
+			continue
+		}
+		var conn Conn
+		if conn, err = p.dial(ep); err == nil {
+			return conn, b, nil
+		}
+		if b != nil {
+			b.done(false)
+		}
 	}
-	return conn, nil
+	return nil, nil, err
 }
 
-func (p *clientConnPool) Close() error{
-	conn,ok:=p.getConn()
-	if !ok{
-		return nil
+func (p *clientConnPool) newEndpoint(addr string) *endpoint {
+	ep := &endpoint{addr: addr}
+	if p.breakerOpts != nil {
+		ep.breaker = newBreaker(addr, p.breakerOpts)
 	}
-	return conn.Close()
+	return ep
 }
 
+// candidates returns the endpoints in the order to try, the endpoints cooling down
+// are skipped unless all of them are cooling down.
+func (p *clientConnPool) candidates() []*endpoint {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := time.Now()
+	n := len(p.endpoints)
+	var healthy []*endpoint
+	var earliest *endpoint
+	for i := 0; i < n; i++ {
+		ep := p.endpoints[(p.next+i)%n]
+		if !ep.failedUntil.After(now) {
+			healthy = append(healthy, ep)
+		} else if earliest == nil || ep.failedUntil.Before(earliest.failedUntil) {
+			earliest = ep
+		}
+	}
+	p.next = (p.next + 1) % n
+	if len(healthy) == 0 {
+		return []*endpoint{earliest}
+	}
+	return healthy
+}
 
-func (p *clientConnPool) doDial() (Conn, error) {
-	call := &dialCall{done: make(chan struct{})}
-	dialing := (*dialCall)(atomic.LoadPointer(p.dialCallAddr))
-	swapped := atomic.CompareAndSwapPointer(p.dialCallAddr, nil, unsafe.Pointer(call))
-	if !swapped {
+// dial returns the connection of the ep, it connects to the ep if needed.
+func (p *clientConnPool) dial(ep *endpoint) (Conn, error) {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil, ErrConnClosed
+	}
+	if ep.conn != nil && !isClosedConn(ep.conn) {
+		conn := ep.conn
+		p.mu.Unlock()
+		return conn, nil
+	}
+	if dialing := ep.dialing; dialing != nil {
 		// A dial call is already in-flight. Don't start another.
+		p.mu.Unlock()
 		<-dialing.done
 		return dialing.resp, dialing.err
 	}
-	// double check.
-	if conn, ok := p.getConn(); ok {
-		return conn,nil
-	}
+	call := &dialCall{done: make(chan struct{})}
+	ep.dialing = call
+	p.mu.Unlock()
 	// Start to connect to the remote server.
 	var nc net.Conn
-	nc, call.err = p.dialer.Dial("tcp", p.addr)
-	if call.err==nil{
+	nc, call.err = p.dialer.Dial("tcp", ep.addr)
+	if call.err == nil {
 		call.resp = p.connCreator(nc)
-		p.putConn(call.resp)
 	}
-	// Note: Deleting the dial call must be performed after storing the connection
-	// If the address is successfully dialed.
-	atomic.StorePointer(p.dialCallAddr, nil)
+	p.mu.Lock()
+	ep.dialing = nil
+	if call.err == nil {
+		ep.conn = call.resp
+		ep.failedUntil = time.Time{}
+	} else {
+		ep.failedUntil = time.Now().Add(p.cooldown)
+	}
+	closed := p.closed
+	p.mu.Unlock()
 	close(call.done)
+	if closed && call.err == nil {
+		call.resp.Close()
+		return nil, ErrConnClosed
+	}
 	return call.resp, call.err
 }
 
-// getInvalidConn returns the connection that can take a new request, (i.e., (respCh, true)).
-// If the connection does not exist it returns (nil,false).
-func (p *clientConnPool) getConn() (Conn, bool) {
-	val := atomic.LoadPointer(p.connAddr)
-	if val == nil {
-		return nil, false
+// goAway stops using the conn, the endpoint of it cools down, and the addr
+// is preferred by the new connections if it's not empty.
+// The addr is kept until the endpoints are re-resolved.
+func (p *clientConnPool) goAway(conn Conn, addr string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	redirect := -1
+	for i, ep := range p.endpoints {
+		if ep.conn == conn {
+			ep.conn = nil
+			ep.failedUntil = time.Now().Add(p.cooldown)
+		}
+		if ep.addr == addr {
+			redirect = i
+		}
+	}
+	if addr == "" {
+		return
+	}
+	if redirect < 0 {
+		p.endpoints = append(p.endpoints, p.newEndpoint(addr))
+		redirect = len(p.endpoints) - 1
+	}
+	p.endpoints[redirect].failedUntil = time.Time{}
+	p.next = redirect
+}
+
+// Close closes all connections and stops re-resolving.
+func (p *clientConnPool) Close() error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil
+	}
+	p.closed = true
+	close(p.quit)
+	var conns []Conn
+	for _, ep := range p.endpoints {
+		if ep.conn != nil {
+			conns = append(conns, ep.conn)
+		}
+	}
+	p.mu.Unlock()
+	var err error
+	for _, conn := range conns {
+		if e := conn.Close(); err == nil {
+			err = e
+		}
 	}
-	conn := *(*Conn)(val)
-	return conn, true
+	return err
 }
 
-// putConn stores the connection.
-func (p *clientConnPool) putConn(conn Conn) {
-	atomic.StorePointer(p.connAddr, unsafe.Pointer(&conn))
+func isClosedConn(conn Conn) bool {
+	c, ok := conn.(interface{ IsClosed() bool })
+	return ok && c.IsClosed()
 }
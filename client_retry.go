@@ -0,0 +1,163 @@
+package gosocket
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net"
+	"time"
+
+	"github.com/happyxcj/gosocket/pkts"
+	"github.com/happyxcj/gosocket/protocol"
+)
+
+const (
+	defaultRetryAttempts  = 3
+	defaultRetryBaseDelay = 100 * time.Millisecond
+	defaultRetryMaxDelay  = 2 * time.Second
+)
+
+type RetryOpts struct {
+	// maxAttempts is the max number of the attempts including the first one.
+	// It's default value is 3.
+	maxAttempts int
+	// backoff returns the delay before the n-th retry, the n starts from 1.
+	// It's default value is "ExponentialBackoff(100*time.Millisecond, 2*time.Second)".
+	backoff func(n int) time.Duration
+	// retryable reports whether the req failed with the err can be retried,
+	// it overrides the default classification, see Retryable.
+	retryable func(req protocol.Packet, err error) bool
+	// idempotent contains the cmds of the requests that can be processed more than once.
+	idempotent map[uint16]bool
+}
+
+// RetryOpt specifies an option for the retry policy.
+type RetryOpt func(*RetryOpts)
+
+// RetryMaxAttempts returns a RetryOpt to set the max number of the attempts
+// including the first one.
+func RetryMaxAttempts(n int) RetryOpt {
+	return func(o *RetryOpts) {
+		o.maxAttempts = n
+	}
+}
+
+// RetryBackoff returns a RetryOpt to set the function returning the delay
+// before the n-th retry.
+func RetryBackoff(backoff func(n int) time.Duration) RetryOpt {
+	return func(o *RetryOpts) {
+		o.backoff = backoff
+	}
+}
+
+// RetryOn returns a RetryOpt to set the function reporting whether the req
+// failed with the err can be retried.
+func RetryOn(retryable func(req protocol.Packet, err error) bool) RetryOpt {
+	return func(o *RetryOpts) {
+		o.retryable = retryable
+	}
+}
+
+// RetryIdempotent returns a RetryOpt to mark the requests with the cmds as idempotent,
+// they are also retried if they may have been processed by the server.
+func RetryIdempotent(cmds ...uint16) RetryOpt {
+	return func(o *RetryOpts) {
+		if o.idempotent == nil {
+			o.idempotent = make(map[uint16]bool)
+		}
+		for _, cmd := range cmds {
+			o.idempotent[cmd] = true
+		}
+	}
+}
+
+// ExponentialBackoff returns a backoff doubling the delay from the base to the max,
+// a random jitter of up to half of the delay is subtracted.
+func ExponentialBackoff(base, max time.Duration) func(n int) time.Duration {
+	return func(n int) time.Duration {
+		d := base
+		for i := 1; i < n && d < max; i++ {
+			d *= 2
+		}
+		if d > max {
+			d = max
+		}
+		if half := int64(d / 2); half > 0 {
+			d -= time.Duration(rand.Int63n(half))
+		}
+		return d
+	}
+}
+
+// Retryable reports whether a request failed with the err can be retried by default.
+//
+// The requests failed before being processed by the server are always retried, such as
+// the failures to connect, ErrCircuitOpen, ErrNoCredit and the status "StatusUnavailable" or
+// "StatusRateLimited". The idempotent ones are also retried on ErrTimeout,
+// ErrConnClosed and ErrSlowConsumer.
+func Retryable(err error, idempotent bool) bool {
+	switch {
+	case errors.Is(err, ErrCircuitOpen), errors.Is(err, ErrNoCredit):
+		return true
+	case errors.Is(err, ErrTimeout), errors.Is(err, ErrConnClosed), errors.Is(err, ErrSlowConsumer):
+		return idempotent
+	}
+	var st *pkts.Status
+	if errors.As(err, &st) {
+		return st.Code == pkts.StatusUnavailable || st.Code == pkts.StatusRateLimited
+	}
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+// shouldRetry reports whether the req failed with the err at the attempt should be retried,
+// the unsent indicates the req is known not to be sent.
+func (o *RetryOpts) shouldRetry(ctx context.Context, req protocol.Packet, attempt int, err error, unsent bool) bool {
+	if attempt >= o.maxAttempts || ctx.Err() != nil {
+		return false
+	}
+	if o.retryable != nil {
+		return o.retryable(req, err)
+	}
+	idempotent := unsent
+	if data, ok := req.(pkts.DataPkt); ok && o.idempotent[data.Cmd()] {
+		idempotent = true
+	}
+	return Retryable(err, idempotent)
+}
+
+// wait waits for the backoff before the n-th retry.
+// It returns false if the ctx is done before that.
+func (o *RetryOpts) wait(ctx context.Context, n int) bool {
+	d := o.backoff(n)
+	if d <= 0 {
+		return true
+	}
+	t := acquireTimer(d)
+	defer releaseTimer(t)
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// isFailure reports whether the err indicates the server is unhealthy,
+// it's recorded by the circuit breaker of the server.
+// The errors of the context of the caller are not failures of the server,
+// although context.DeadlineExceeded is also a net.Error.
+func isFailure(err error) bool {
+	switch {
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return false
+	case errors.Is(err, ErrTimeout), errors.Is(err, ErrConnClosed), errors.Is(err, ErrSlowConsumer):
+		return true
+	}
+	var st *pkts.Status
+	if errors.As(err, &st) {
+		return st.Code == pkts.StatusUnavailable || st.Code == pkts.StatusDeadlineExceeded
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
@@ -0,0 +1,53 @@
+package gosocket
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/happyxcj/gosocket/pkts"
+)
+
+func TestRetryable(t *testing.T) {
+	tests := []struct {
+		err        error
+		idempotent bool
+		want       bool
+	}{
+		{ErrCircuitOpen, false, true},
+		{fmt.Errorf("send: %w", ErrNoCredit), false, true},
+		{fmt.Errorf("request: %w", ErrTimeout), false, false},
+		{fmt.Errorf("request: %w", ErrTimeout), true, true},
+		{fmt.Errorf("request: %w", pkts.NewStatus(pkts.StatusUnavailable, "")), false, true},
+		{pkts.NewStatus(pkts.StatusInternal, ""), true, false},
+		{fmt.Errorf("dial: %w", &net.OpError{Op: "dial", Err: errors.New("refused")}), false, true},
+		{&net.OpError{Op: "read", Err: errors.New("reset")}, false, false},
+	}
+	for _, tt := range tests {
+		if got := Retryable(tt.err, tt.idempotent); got != tt.want {
+			t.Errorf("Retryable(%v, %v) = %v, want %v", tt.err, tt.idempotent, got, tt.want)
+		}
+	}
+}
+
+func TestIsFailure(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{context.DeadlineExceeded, false},
+		{fmt.Errorf("wait: %w", context.DeadlineExceeded), false},
+		{context.Canceled, false},
+		{fmt.Errorf("request: %w", ErrTimeout), true},
+		{fmt.Errorf("request: %w", pkts.NewStatus(pkts.StatusUnavailable, "")), true},
+		{pkts.NewStatus(pkts.StatusNotFound, ""), false},
+		{&net.OpError{Op: "read", Err: errors.New("reset")}, true},
+	}
+	for _, tt := range tests {
+		if got := isFailure(tt.err); got != tt.want {
+			t.Errorf("isFailure(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
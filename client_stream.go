@@ -0,0 +1,160 @@
+package gosocket
+
+import (
+	"context"
+	"errors"
+	"io"
+
+	"github.com/happyxcj/gosocket/pkts"
+	"github.com/happyxcj/gosocket/protocol"
+)
+
+var (
+	// ErrStreamOverflow is returned when the server sends more responses than the window.
+	ErrStreamOverflow = errors.New("the server sends more responses than the window")
+
+	// ErrStreamClosed is returned when receiving from a closed stream.
+	ErrStreamClosed = errors.New("the stream has been closed")
+)
+
+// RespStream receives a stream of responses for a request, see route.StreamWriter.
+// It's not safe for concurrent use.
+type RespStream struct {
+	c           *Client
+	cl          *call
+	seqId       uint16
+	originSeqId uint16
+	ctx         context.Context
+	cancel      context.CancelFunc
+	window      uint32
+	// consumed is the number of the received responses not granted to the server.
+	consumed uint32
+	// err is the reason why the stream is finished.
+	err error
+	// breaker records the result of the stream, it's nil if disabled.
+	breaker *breaker
+}
+
+// Stream sends a request with the flag "FlagStream", and returns a RespStream
+// to receive the stream of responses.
+//
+// The server can send at most the stream window of the client of responses
+// before they are received by Recv, which is sent by the property "PropWindow",
+// and the received ones are granted to the server by WindowPkt.
+// The response timeout of the client is not applied.
+//
+// The stream must be closed by Close unless Recv has returned an error.
+// The result of the stream is recorded by the circuit breaker of the server
+// when it's finished. Like Request, a copy of the pkt is sent.
+func (c *Client) Stream(ctx context.Context, pkt pkts.ReqRespPkt) (*RespStream, error) {
+	pkt, err := pkts.CloneReq(pkt)
+	if err != nil {
+		return nil, err
+	}
+	adder, ok := pkt.(interface{ AddFlags(...protocol.PktFlags) })
+	if !ok {
+		return nil, protocol.ErrInvalidPktKind
+	}
+	conn, b, err := c.pool.get(true)
+	if err != nil {
+		return nil, err
+	}
+	window := c.opts.streamWindow
+	if window == 0 {
+		window = defaultStreamWindow
+	}
+	// The last response takes no credit.
+	cl := &call{conn: conn, respCh: make(chan pkts.ReqRespPkt, window+1), stream: true}
+	seqId, err := c.addCall(cl)
+	if err != nil {
+		b.report(err)
+		return nil, err
+	}
+	s := &RespStream{c: c, cl: cl, breaker: b, seqId: seqId, originSeqId: pkt.SeqId(), window: window}
+	s.ctx, s.cancel = context.WithCancel(ctx)
+	deadline, _ := ctx.Deadline()
+	pkts.SetDeadline(pkt, deadline)
+	pkt.Props().WithUint32(pkts.PropWindow, window)
+	adder.AddFlags(pkts.FlagStream)
+	pkt.SetSeqId(seqId)
+	if err = conn.Send(pkt); err != nil {
+		c.removeCall(seqId, cl, false)
+		s.finish(err)
+		return nil, err
+	}
+	return s, nil
+}
+
+// Recv returns the next response. It returns io.EOF after the last response,
+// the last response is returned only if it has the application message.
+// If a response carries an error status, it returns the *pkts.Status as the error.
+func (s *RespStream) Recv() (pkts.ReqRespPkt, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	select {
+	case resp, ok := <-s.cl.respCh:
+		if !ok {
+			err := s.cl.err
+			if err == nil {
+				err = ErrConnClosed
+			}
+			s.finish(err)
+			return nil, err
+		}
+		resp.SetSeqId(s.originSeqId)
+		if st, ok := pkts.GetStatus(resp); ok {
+			s.finish(st)
+			return nil, st
+		}
+		if !resp.Flags().Has(pkts.FlagMore) {
+			s.finish(io.EOF)
+			if len(resp.Body()) == 0 {
+				return nil, io.EOF
+			}
+			return resp, nil
+		}
+		s.consume()
+		return resp, nil
+	case <-s.ctx.Done():
+		err := s.ctx.Err()
+		if err == context.DeadlineExceeded {
+			err = ErrTimeout
+		}
+		s.abort(err)
+		return nil, err
+	}
+}
+
+// Close cancels the stream if it's not finished.
+func (s *RespStream) Close() error {
+	if s.err == nil {
+		s.abort(ErrStreamClosed)
+	}
+	return nil
+}
+
+// consume grants the received responses to the server when half of the window is used.
+func (s *RespStream) consume() {
+	s.consumed++
+	if s.consumed >= (s.window+1)/2 {
+		s.cl.conn.Send(pkts.NewWindowPkt(s.seqId, s.consumed))
+		s.consumed = 0
+	}
+}
+
+// abort cancels the stream on the server before the seqId can be reused.
+func (s *RespStream) abort(err error) {
+	s.c.removeCall(s.seqId, s.cl, true)
+	s.finish(err)
+}
+
+// finish finishes the stream with the err, and records the result of it.
+func (s *RespStream) finish(err error) {
+	s.err = err
+	s.cancel()
+	if err == io.EOF {
+		err = nil
+	}
+	s.breaker.report(err)
+}
@@ -0,0 +1,199 @@
+package gosocket
+
+import (
+	"context"
+	"sync"
+
+	"github.com/happyxcj/gosocket/pkts"
+)
+
+// SubManager tracks the active subscriptions of a client, it dispatches the published
+// packets to the subscriptions and re-issues the subscriptions of a connection on another
+// one after the connection is closed or goes away.
+//
+// A published packet is dispatched to the subscriptions with the same subscription id
+// if it has the property "PropSubId", otherwise to the subscriptions with the same topic.
+// The topic of a subscription is the property "PropTopic" of the subscribing response,
+// or the property "PropTopic" of the subscribing request if the response has none.
+type SubManager struct {
+	c    *Client
+	mu   sync.Mutex
+	subs []*Subscription
+	// resubMu serializes the re-issuing of the subscriptions.
+	resubMu sync.Mutex
+}
+
+// Subscription represents an active subscription.
+type Subscription struct {
+	m *SubManager
+	// req is the subscribing request, it will be re-issued after the client reconnects.
+	req     *pkts.SubPkt
+	handler func(*pkts.PubPkt)
+	// The following fields are protected by the lock of the SubManager.
+	// conn is the connection the subscription is issued on.
+	conn     Conn
+	topic    string
+	subId    uint16
+	hasSubId bool
+	// lastSeq is the sequence number of the latest received packet,
+	// it's used to request the missed packets when re-issuing the subscription.
+	lastSeq uint64
+}
+
+func newSubManager(c *Client) *SubManager {
+	return &SubManager{c: c}
+}
+
+// Topic returns the topic of the subscription.
+func (s *Subscription) Topic() string {
+	s.m.mu.Lock()
+	topic := s.topic
+	s.m.mu.Unlock()
+	return topic
+}
+
+// Len returns the number of the active subscriptions.
+func (m *SubManager) Len() int {
+	m.mu.Lock()
+	n := len(m.subs)
+	m.mu.Unlock()
+	return n
+}
+
+// Subscribe sends the subscribing request and tracks the subscription if it succeeds.
+// The handler is called in the receiving goroutine for every published packet
+// of the subscription.
+//
+// The pkt is owned by the manager after calling, it will be re-issued
+// after the client reconnects.
+func (m *SubManager) Subscribe(ctx context.Context, pkt *pkts.SubPkt, handler func(*pkts.PubPkt)) (*Subscription, *pkts.SubPkt, error) {
+	s := &Subscription{m: m, req: pkt, handler: handler}
+	resp, err := m.c.request(ctx, pkt, func(conn Conn, resp pkts.ReqRespPkt) {
+		if _, failed := pkts.GetStatus(resp); failed {
+			return
+		}
+		// Track the subscription before any published packet is received.
+		m.mu.Lock()
+		m.update(s, conn, resp)
+		m.subs = append(m.subs, s)
+		m.mu.Unlock()
+	})
+	if err != nil {
+		m.remove(s)
+		return nil, nil, err
+	}
+	subResp, ok := resp.(*pkts.SubPkt)
+	if !ok {
+		m.remove(s)
+		return nil, nil, ErrPktDisorder
+	}
+	return s, subResp, nil
+}
+
+// Unsubscribe stops tracking the s and sends the unsubscribing request.
+func (m *SubManager) Unsubscribe(ctx context.Context, s *Subscription, pkt *pkts.UnsubPkt) (*pkts.UnsubPkt, error) {
+	m.remove(s)
+	return m.c.Unsubscribe(ctx, pkt)
+}
+
+// update updates the s by the subscribing response received from the conn
+// with the lock held.
+func (m *SubManager) update(s *Subscription, conn Conn, resp pkts.ReqRespPkt) {
+	s.conn = conn
+	var ok bool
+	if s.topic, ok = resp.Props().GetStr(pkts.PropTopic); !ok {
+		s.topic, _ = s.req.Props().GetStr(pkts.PropTopic)
+	}
+	s.subId, s.hasSubId = s.req.Props().GetUint16(pkts.PropSubId)
+}
+
+func (m *SubManager) remove(s *Subscription) {
+	m.mu.Lock()
+	for i, v := range m.subs {
+		if v == s {
+			m.subs = append(m.subs[:i], m.subs[i+1:]...)
+			break
+		}
+	}
+	m.mu.Unlock()
+}
+
+// dispatch delivers the p to the matched subscriptions.
+// It returns false if there is no matched subscription.
+func (m *SubManager) dispatch(p *pkts.PubPkt) bool {
+	subId, hasSubId := p.Props().GetUint16(pkts.PropSubId)
+	seq, hasSeq := p.Props().GetUint64(pkts.PropSeq)
+	var matched []*Subscription
+	m.mu.Lock()
+	for _, s := range m.subs {
+		if hasSubId {
+			if !s.hasSubId || s.subId != subId {
+				continue
+			}
+		} else if s.topic != p.Topic() {
+			continue
+		}
+		if hasSeq {
+			s.lastSeq = seq
+		}
+		matched = append(matched, s)
+	}
+	m.mu.Unlock()
+	for _, s := range matched {
+		s.handler(p)
+	}
+	return len(matched) > 0
+}
+
+// hasConn reports whether any subscription is issued on the conn.
+func (m *SubManager) hasConn(conn Conn) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, s := range m.subs {
+		if s.conn == conn {
+			return true
+		}
+	}
+	return false
+}
+
+// resubscribe re-issues the subscriptions of the conn on another connection, the packets
+// published after the latest received packet are requested by the property "PropSinceSeq".
+// The subscriptions rejected by the server are removed. It stops at the first
+// failure of the others and returns the error, the remaining subscriptions
+// are still of the conn.
+func (m *SubManager) resubscribe(conn Conn) error {
+	m.resubMu.Lock()
+	defer m.resubMu.Unlock()
+	m.mu.Lock()
+	var subs []*Subscription
+	for _, s := range m.subs {
+		if s.conn == conn {
+			subs = append(subs, s)
+		}
+	}
+	m.mu.Unlock()
+	for _, s := range subs {
+		m.mu.Lock()
+		if s.lastSeq > 0 {
+			s.req.Props().WithUint64(pkts.PropSinceSeq, s.lastSeq)
+		}
+		m.mu.Unlock()
+		_, err := m.c.request(context.Background(), s.req, func(conn Conn, resp pkts.ReqRespPkt) {
+			if _, failed := pkts.GetStatus(resp); failed {
+				return
+			}
+			m.mu.Lock()
+			m.update(s, conn, resp)
+			m.mu.Unlock()
+		})
+		if _, rejected := err.(*pkts.Status); rejected {
+			m.remove(s)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
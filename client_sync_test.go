@@ -0,0 +1,30 @@
+package gosocket
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/happyxcj/gosocket/pkts"
+)
+
+func TestRequestKeepsPkt(t *testing.T) {
+	_, addr := startServer(t)
+	c := NewClient(addr)
+	defer c.Close()
+	pkt := pkts.NewEasyHttpPkt(7, 1, nil)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	for i := 0; i < 2; i++ {
+		// The request is replied with "StatusNotFound" by the server.
+		if _, err := c.Http(ctx, pkt); err == nil {
+			t.Fatal("expect an error")
+		}
+		if pkt.SeqId() != 7 {
+			t.Fatalf("the sequence id is modified: %d", pkt.SeqId())
+		}
+		if _, ok := pkts.GetDeadline(pkt); ok {
+			t.Fatal("the deadline is set to the pkt")
+		}
+	}
+}
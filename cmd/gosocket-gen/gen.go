@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"go/format"
+	"text/template"
+)
+
+var tmpl = template.Must(template.New("service").Parse(`// Code generated by gosocket-gen. DO NOT EDIT.
+
+package {{.Package}}
+
+import (
+{{- if .HasReq}}
+	"context"
+{{end}}
+	"github.com/happyxcj/gosocket"
+	"github.com/happyxcj/gosocket/pkts"
+	"github.com/happyxcj/gosocket/route"
+{{range .Imports}}
+	"{{.}}"
+{{- end}}
+)
+
+// {{.Name}}Server is the server API for the {{.Name}} service.
+type {{.Name}}Server interface {
+{{- range .Methods}}
+{{- if .IsReq}}
+	{{.Name}}(c *route.Context, req *{{.Request}}) (*{{.Response}}, error)
+{{- else}}
+	{{.Name}}(c *route.Context, req *{{.Request}}) error
+{{- end}}
+{{- end}}
+}
+
+// Register{{.Name}}Server registers the routes of the srv in the rc.
+func Register{{.Name}}Server(rc *route.RouterCenter, srv {{.Name}}Server) {
+{{- range .Methods}}
+	route.HandleTyped(rc.GroupOf(pkts.{{.PktKind}}),
+		route.RouteKey{Cmd: {{.Cmd}}, Version: {{.Version}}, Codec: {{.Codec}}},
+{{- if .IsReq}}
+		srv.{{.Name}})
+{{- else}}
+		func(c *route.Context, req *{{.Request}}) (*struct{}, error) {
+			return nil, srv.{{.Name}}(c, req)
+		})
+{{- end}}
+{{- end}}
+}
+
+// {{.Name}}Client is the client API for the {{.Name}} service.
+type {{.Name}}Client struct {
+	c *gosocket.Client
+}
+
+func New{{.Name}}Client(c *gosocket.Client) *{{.Name}}Client {
+	return &{{.Name}}Client{c: c}
+}
+{{range .Methods}}
+{{- if .IsReq}}
+func (c *{{$.Name}}Client) {{.Name}}(ctx context.Context, req *{{.Request}}) (*{{.Response}}, error) {
+	pkt := {{.NewPkt}}
+	if err := pkts.EncodeBody(pkt, req); err != nil {
+		return nil, err
+	}
+	resp, err := c.c.Request(ctx, pkt)
+	if err != nil {
+		return nil, err
+	}
+	out := new({{.Response}})
+	if err = pkts.DecodeBody(resp, out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+{{else}}
+func (c *{{$.Name}}Client) {{.Name}}(req *{{.Request}}) error {
+	pkt := {{.NewPkt}}
+	if err := pkts.EncodeBody(pkt, req); err != nil {
+		return err
+	}
+	return c.c.Send(pkt)
+}
+{{end}}
+{{- end}}
+`))
+
+// tmplData is the data of the template.
+type tmplData struct {
+	Package string
+	// Name is the name of the service.
+	Name    string
+	Imports []string
+	Methods []Method
+	// HasReq indicates whether any method has a response.
+	HasReq bool
+}
+
+// generate returns the formatted source code of the svc.
+func generate(svc *Service) ([]byte, error) {
+	data := tmplData{Package: svc.Package, Name: svc.Service, Imports: svc.Imports, Methods: svc.Methods}
+	for _, m := range svc.Methods {
+		if m.IsReq() {
+			data.HasReq = true
+		}
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return nil, err
+	}
+	return format.Source(buf.Bytes())
+}
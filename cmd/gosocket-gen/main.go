@@ -0,0 +1,73 @@
+// Command gosocket-gen generates the typed server registration code for route
+// and the typed client methods for the sync client from a service description.
+//
+// Usage:
+//
+//	gosocket-gen -in service.json -out service_gen.go
+//
+// The service description is a JSON file as follows:
+//
+//	{
+//		"package": "chat",
+//		"service": "Chat",
+//		"imports": ["example.com/chat/models"],
+//		"methods": [
+//			{"name": "Send", "kind": "http", "cmd": 1000, "version": 1, "codec": 0,
+//				"request": "models.SendReq", "response": "models.SendResp"},
+//			{"name": "Typing", "kind": "notify", "cmd": 1001, "request": "models.Typing"}
+//		]
+//	}
+//
+// The kind is one of "http", "subscribe", "unsubscribe" and "notify",
+// a "notify" method has no response. The request and response are the names
+// of the message types, which are qualified by the imported packages if needed.
+//
+// For the above description, it generates:
+//
+//	type ChatServer interface {
+//		Send(c *route.Context, req *models.SendReq) (*models.SendResp, error)
+//		Typing(c *route.Context, req *models.Typing) error
+//	}
+//	func RegisterChatServer(rc *route.RouterCenter, srv ChatServer)
+//
+//	type ChatClient struct { ... }
+//	func NewChatClient(c *gosocket.Client) *ChatClient
+//	func (c *ChatClient) Send(ctx context.Context, req *models.SendReq) (*models.SendResp, error)
+//	func (c *ChatClient) Typing(req *models.Typing) error
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+)
+
+func main() {
+	in := flag.String("in", "", "the service description file")
+	out := flag.String("out", "", "the generated file, the default is the standard output")
+	flag.Parse()
+	if *in == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err := run(*in, *out); err != nil {
+		fmt.Fprintln(os.Stderr, "gosocket-gen:", err)
+		os.Exit(1)
+	}
+}
+
+func run(in, out string) error {
+	svc, err := loadService(in)
+	if err != nil {
+		return err
+	}
+	src, err := generate(svc)
+	if err != nil {
+		return err
+	}
+	if out == "" {
+		_, err = os.Stdout.Write(src)
+		return err
+	}
+	return os.WriteFile(out, src, 0644)
+}
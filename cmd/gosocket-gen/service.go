@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go/token"
+	"os"
+)
+
+// Service describes a service.
+type Service struct {
+	Package string   `json:"package"`
+	Service string   `json:"service"`
+	Imports []string `json:"imports"`
+	Methods []Method `json:"methods"`
+}
+
+// Method describes a command of the service.
+type Method struct {
+	Name     string `json:"name"`
+	Kind     string `json:"kind"`
+	Cmd      uint16 `json:"cmd"`
+	Version  byte   `json:"version"`
+	Codec    byte   `json:"codec"`
+	Request  string `json:"request"`
+	Response string `json:"response"`
+}
+
+// kindInfo describes how to generate the code for a packet kind.
+type kindInfo struct {
+	// kind is the name of the packet kind constant in the package pkts.
+	kind string
+	// newPkt is the name of the function to create a full packet in the package pkts.
+	newPkt string
+	// isReq indicates whether the packet is a ReqRespPkt.
+	isReq bool
+}
+
+var kinds = map[string]kindInfo{
+	"http":        {kind: "KindHttp", newPkt: "NewFullHttpPkt", isReq: true},
+	"subscribe":   {kind: "KindSubscribe", newPkt: "NewFullSubPkt", isReq: true},
+	"unsubscribe": {kind: "KindUnsubscribe", newPkt: "NewFullUnsubPkt", isReq: true},
+	"notify":      {kind: "KindNotify", newPkt: "NewFullNotifyPkt"},
+}
+
+const (
+	// maxVersion and maxCodec are limited by the bits of the packet head.
+	maxVersion = 1<<5 - 1
+	maxCodec   = 1<<3 - 1
+)
+
+func loadService(name string) (*Service, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	svc := &Service{}
+	if err = json.Unmarshal(data, svc); err != nil {
+		return nil, err
+	}
+	if err = svc.validate(); err != nil {
+		return nil, err
+	}
+	return svc, nil
+}
+
+func (s *Service) validate() error {
+	if !token.IsIdentifier(s.Package) {
+		return fmt.Errorf("invalid package name '%v'", s.Package)
+	}
+	if !token.IsIdentifier(s.Service) || !token.IsExported(s.Service) {
+		return fmt.Errorf("invalid service name '%v'", s.Service)
+	}
+	if len(s.Methods) == 0 {
+		return errors.New("no method")
+	}
+	type key struct {
+		kind           string
+		cmd            uint16
+		version, codec byte
+	}
+	names := make(map[string]bool)
+	keys := make(map[key]bool)
+	for _, m := range s.Methods {
+		if !token.IsIdentifier(m.Name) || !token.IsExported(m.Name) {
+			return fmt.Errorf("invalid method name '%v'", m.Name)
+		}
+		if names[m.Name] {
+			return fmt.Errorf("duplicate method '%v'", m.Name)
+		}
+		names[m.Name] = true
+		info, ok := kinds[m.Kind]
+		if !ok {
+			return fmt.Errorf("method '%v': invalid kind '%v'", m.Name, m.Kind)
+		}
+		if m.Version > maxVersion || m.Codec > maxCodec {
+			return fmt.Errorf("method '%v': version or codec out of range", m.Name)
+		}
+		if m.Request == "" {
+			return fmt.Errorf("method '%v': no request type", m.Name)
+		}
+		if info.isReq && m.Response == "" {
+			return fmt.Errorf("method '%v': no response type", m.Name)
+		}
+		if !info.isReq && m.Response != "" {
+			return fmt.Errorf("method '%v': the kind '%v' has no response", m.Name, m.Kind)
+		}
+		k := key{m.Kind, m.Cmd, m.Version, m.Codec}
+		if keys[k] {
+			return fmt.Errorf("method '%v': duplicate command %v-%v-%v", m.Name, m.Cmd, m.Version, m.Codec)
+		}
+		keys[k] = true
+	}
+	return nil
+}
+
+// Info returns the kindInfo of the method.
+func (m Method) Info() kindInfo {
+	return kinds[m.Kind]
+}
+
+// IsReq reports whether the method has a response.
+func (m Method) IsReq() bool {
+	return m.Info().isReq
+}
+
+// PktKind returns the name of the packet kind constant.
+func (m Method) PktKind() string {
+	return m.Info().kind
+}
+
+// NewPkt returns the expression to create the request packet.
+func (m Method) NewPkt() string {
+	if m.IsReq() {
+		return fmt.Sprintf("pkts.%v(0, %v, %v, %v, nil)", m.Info().newPkt, m.Cmd, m.Version, m.Codec)
+	}
+	return fmt.Sprintf("pkts.%v(%v, %v, %v, nil)", m.Info().newPkt, m.Cmd, m.Version, m.Codec)
+}
@@ -19,3 +19,18 @@ type Conn interface {
 	Close() error
 }
 
+// Router routes the received packets to their handlers.
+type Router interface {
+
+	// HandlePacket handles the p received from the c.
+	// It returns a bool indicating whether the p is handled.
+	HandlePacket(c Conn, p protocol.Packet) bool
+}
+
+// Drainer is implemented by the routers that handle the requests asynchronously,
+// so the server can wait for them to finish during Server.Shutdown.
+type Drainer interface {
+
+	// Inflight returns the number of the in-flight requests received from the c.
+	Inflight(c Conn) int
+}
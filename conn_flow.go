@@ -0,0 +1,109 @@
+package gosocket
+
+import (
+	"github.com/happyxcj/gosocket/pkts"
+	"github.com/happyxcj/gosocket/protocol"
+)
+
+// flowControlled reports whether the p takes a credit of the connection.
+// The control packets and the StreamPkts, which have their own flow control,
+// take no credit, so they can always be sent.
+func flowControlled(p protocol.Packet) bool {
+	switch p.Kind() {
+	case pkts.KindPing, pkts.KindCancel, pkts.KindWindow, pkts.KindStream, pkts.KindGoAway:
+		return false
+	}
+	return true
+}
+
+// isLimited reports whether the remote peer has advertised its window.
+func (c *QueueConn) isLimited() bool {
+	c.flowMu.Lock()
+	limited := c.limited
+	c.flowMu.Unlock()
+	return limited
+}
+
+// grant increases the credits by the n. The first grant is the window advertised
+// by the remote peer, the packets sent before it are taken into account.
+func (c *QueueConn) grant(n uint32) {
+	c.flowMu.Lock()
+	if !c.limited {
+		c.limited = true
+		c.credits = int64(n) - c.sent
+	} else {
+		c.credits += int64(n)
+	}
+	c.flowMu.Unlock()
+	select {
+	case c.creditCh <- struct{}{}:
+	default:
+	}
+}
+
+// noCredit reports whether the credits granted by the remote peer are used up.
+func (c *QueueConn) noCredit() bool {
+	c.flowMu.Lock()
+	defer c.flowMu.Unlock()
+	return c.limited && c.credits <= 0
+}
+
+// acquire takes a credit to send the p, it waits for the credit if needed
+// and sends the control packets meanwhile.
+// It returns a non-nil error if the connection fails or starts closing,
+// the waiting is also given up by Close.
+func (c *QueueConn) acquire(p protocol.Packet) error {
+	if !flowControlled(p) {
+		return nil
+	}
+	for {
+		c.flowMu.Lock()
+		if !c.limited || c.credits > 0 {
+			c.credits--
+			c.sent++
+			c.flowMu.Unlock()
+			return nil
+		}
+		c.flowMu.Unlock()
+		select {
+		case <-c.creditCh:
+		case ctrl := <-c.ctrlCh:
+			if err := c.Conn.Send(ctrl); err != nil {
+				return err
+			}
+		case <-c.ctx.Done():
+			return ErrConnClosed
+		case <-c.closing:
+			return ErrClosedActively
+		}
+	}
+}
+
+// consume is called when the p received from the remote peer is handled,
+// the consumed credits are granted back when half of the window is used.
+func (c *QueueConn) consume(p protocol.Packet) {
+	if c.recvWindow == 0 || !flowControlled(p) {
+		return
+	}
+	c.flowMu.Lock()
+	c.consumed++
+	n := c.consumed
+	if n < (c.recvWindow+1)/2 {
+		c.flowMu.Unlock()
+		return
+	}
+	c.consumed = 0
+	c.flowMu.Unlock()
+	c.Send(pkts.NewConnWindowPkt(n))
+}
+
+// handleFlowPkt handles the WindowPkt of the connection.
+// It returns false if the p is not handled.
+func (c *QueueConn) handleFlowPkt(p protocol.Packet) bool {
+	pkt, ok := p.(*pkts.WindowPkt)
+	if !ok || !pkt.Flags().Has(pkts.FlagConnWindow) {
+		return false
+	}
+	c.grant(pkt.Credits())
+	return true
+}
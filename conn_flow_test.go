@@ -0,0 +1,151 @@
+package gosocket
+
+import (
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/happyxcj/gosocket/pkts"
+	"github.com/happyxcj/gosocket/protocol"
+)
+
+// chanConn is a Conn that delivers the sent packets to a channel,
+// and receives nothing until it's closed.
+type chanConn struct {
+	sent   chan protocol.Packet
+	closed chan struct{}
+	once   sync.Once
+}
+
+func newChanConn() *chanConn {
+	return &chanConn{sent: make(chan protocol.Packet, 16), closed: make(chan struct{})}
+}
+
+func (c *chanConn) Send(p protocol.Packet) error {
+	select {
+	case c.sent <- p:
+		return nil
+	case <-c.closed:
+		return io.ErrClosedPipe
+	}
+}
+
+func (c *chanConn) Receive() (protocol.Packet, error) {
+	<-c.closed
+	return nil, io.EOF
+}
+
+func (c *chanConn) Close() error {
+	c.once.Do(func() { close(c.closed) })
+	return nil
+}
+
+// expectSent returns the next packet sent by the c, or nil if there's none.
+func expectSent(t *testing.T, c *chanConn, wait time.Duration) protocol.Packet {
+	t.Helper()
+	select {
+	case p := <-c.sent:
+		return p
+	case <-time.After(wait):
+		return nil
+	}
+}
+
+func TestGrantCountsSent(t *testing.T) {
+	c := newQueueConn(newChanConn())
+	c.sent = 3
+	c.grant(5)
+	if !c.isLimited() || c.credits != 2 {
+		t.Fatalf("expect 2 credits, got %d", c.credits)
+	}
+	c.grant(1)
+	if c.credits != 3 {
+		t.Fatalf("expect 3 credits, got %d", c.credits)
+	}
+}
+
+func TestConnCredits(t *testing.T) {
+	raw := newChanConn()
+	c := NewQueueConn(raw)
+	defer c.Close()
+	c.handleFlowPkt(pkts.NewConnWindowPkt(1))
+
+	c.Send(pkts.NewEasyNotifyPkt(1, nil))
+	c.Send(pkts.NewEasyNotifyPkt(2, nil))
+	if p := expectSent(t, raw, time.Second); p == nil {
+		t.Fatal("expect the first packet sent")
+	}
+	if p := expectSent(t, raw, 20*time.Millisecond); p != nil {
+		t.Fatalf("expect no packet sent without credit, got %v", p.Desc())
+	}
+	// The control packets bypass the packets waiting for the credits.
+	c.Send(pkts.NewPingPkt())
+	if p := expectSent(t, raw, time.Second); p == nil || p.Kind() != pkts.KindPing {
+		t.Fatalf("expect the ping sent, got %v", p)
+	}
+	c.handleFlowPkt(pkts.NewConnWindowPkt(1))
+	if p := expectSent(t, raw, time.Second); p == nil || p.Kind() == pkts.KindPing {
+		t.Fatalf("expect the second packet sent, got %v", p)
+	}
+}
+
+func TestConnNoCredit(t *testing.T) {
+	var pending []protocol.Packet
+	done := make(chan struct{})
+	raw := newChanConn()
+	c := newQueueConn(raw, SendChSize(1), PendingPktsHandler(func(ps []protocol.Packet) {
+		pending = ps
+	}), OnClose(func(error) { close(done) }))
+	c.grant(0)
+	c.start()
+
+	if err := c.Send(pkts.NewEasyNotifyPkt(1, nil)); err != nil {
+		t.Fatal(err)
+	}
+	// Wait for the sending goroutine to take the first packet.
+	for len(c.sendCh) > 0 {
+		time.Sleep(time.Millisecond)
+	}
+	if err := c.Send(pkts.NewEasyNotifyPkt(2, nil)); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Send(pkts.NewEasyNotifyPkt(3, nil)); err != ErrNoCredit {
+		t.Fatalf("expect ErrNoCredit, got %v", err)
+	}
+	if c.IsClosed() {
+		t.Fatal("the connection is closed without credit")
+	}
+
+	// Close is not blocked by the packets waiting for the credits.
+	c.Close()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("the connection is not closed")
+	}
+	if len(pending) != 2 {
+		t.Fatalf("expect 2 pending packets, got %d", len(pending))
+	}
+	if p := expectSent(t, raw, 0); p != nil {
+		t.Fatalf("expect no packet sent, got %v", p.Desc())
+	}
+}
+
+func TestConsumeGrants(t *testing.T) {
+	raw := newChanConn()
+	c := newQueueConn(raw, RecvWindow(4))
+	c.consume(pkts.NewPingPkt())
+	c.consume(pkts.NewEasyNotifyPkt(1, nil))
+	if len(c.sendCh) != 0 {
+		t.Fatal("expect no grant before half of the window is used")
+	}
+	c.consume(pkts.NewEasyNotifyPkt(2, nil))
+	if len(c.sendCh) != 1 {
+		t.Fatalf("expect a grant, got %d packets", len(c.sendCh))
+	}
+	p := (<-c.sendCh).(*pkts.WindowPkt)
+	if !p.Flags().Has(pkts.FlagConnWindow) || p.Credits() != 2 {
+		t.Fatalf("expect 2 credits granted, got %d", p.Credits())
+	}
+}
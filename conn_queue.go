@@ -1,7 +1,10 @@
 package gosocket
 
 import (
+	"context"
+	"sync"
 	"sync/atomic"
+	"github.com/happyxcj/gosocket/pkts"
 	"github.com/happyxcj/gosocket/protocol"
 	"fmt"
 	"errors"
@@ -20,6 +23,10 @@ var (
 
 	// ErrSlowConsumer is returned when we attempt to enqueue a packet to a full sending channel.
 	ErrSlowConsumer = errors.New("slow consumer detected")
+
+	// ErrNoCredit is returned when we attempt to enqueue a packet to a full sending channel
+	// while the credits granted by the remote peer are used up.
+	ErrNoCredit = errors.New("no credit granted by the remote peer")
 )
 
 // autoId is used to generate connection unique id.
@@ -48,6 +55,18 @@ type QueueConn struct {
 	// cause represents the reason why the connection was closed.
 	cause error
 
+	// ctx is cancelled when the connection starts closing.
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// closing is closed by Close to make the sending goroutine quit
+	// after the pending packets are sent.
+	closing   chan struct{}
+	closeOnce sync.Once
+
+	// unsent is the packet abandoned by the sending goroutine while waiting for the credits.
+	unsent protocol.Packet
+
 	// pktHandler handles the every received packet.
 	pktHandler func(p protocol.Packet)
 
@@ -58,6 +77,28 @@ type QueueConn struct {
 	// onClose is the callback when both the sending goroutine and receiving goroutine have quit.
 	// The cause represents the reason why the connection was closed.
 	onClose func(cause error)
+
+	// recvWindow is the number of the packets can be sent by the remote peer
+	// before they are handled, a zero value of it disables the flow control.
+	recvWindow uint32
+	// consumeAsync indicates the received packets are consumed by the caller of
+	// consume instead of the return of the pktHandler.
+	consumeAsync bool
+
+	flowMu sync.Mutex
+	// limited indicates whether the remote peer has advertised its window.
+	limited bool
+	// credits is the number of the packets can be sent to the remote peer.
+	credits int64
+	// sent is the number of the packets sent to the remote peer.
+	sent int64
+	// consumed is the number of the handled packets not granted to the remote peer.
+	consumed uint32
+	// creditCh notifies the sending goroutine waiting for the credits.
+	creditCh chan struct{}
+	// ctrlCh is the channel for the control packets, they bypass the packets
+	// waiting for the credits in the sendCh.
+	ctrlCh chan protocol.Packet
 }
 
 type QueueConnOpt func(*QueueConn)
@@ -83,6 +124,22 @@ func PendingPktsHandler(handler func([]protocol.Packet)) QueueConnOpt {
 	}
 }
 
+// RecvWindow returns a QueueConnOpt to enable the flow control of the packets
+// received by the QueueConn, the n is the number of the packets can be sent by
+// the remote peer before they are handled. The window is advertised to the remote
+// peer by a WindowPkt with the flag "FlagConnWindow", and the handled packets are
+// granted back when half of the window is used.
+//
+// A QueueConn pauses sending the packets when the credits granted by the remote
+// peer are used up, except the control packets such as PingPkt, CancelPkt,
+// WindowPkt and StreamPkt. Meanwhile, Send returns ErrNoCredit if the sending
+// channel is full, and SendContext waits for the space of it.
+func RecvWindow(n uint32) QueueConnOpt {
+	return func(c *QueueConn) {
+		c.recvWindow = n
+	}
+}
+
 // OnClose returns a QueueConnOpt to set the closing callback for the QueueConn.
 func OnClose(onClose func(error)) QueueConnOpt {
 	return func(c *QueueConn) {
@@ -91,6 +148,14 @@ func OnClose(onClose func(error)) QueueConnOpt {
 }
 
 func NewQueueConn(conn Conn, opts ...QueueConnOpt) *QueueConn {
+	c := newQueueConn(conn, opts...)
+	c.start()
+	return c
+}
+
+// newQueueConn returns a QueueConn without starting the sending goroutine
+// and receiving goroutine, so the caller can complete the initialization safely.
+func newQueueConn(conn Conn, opts ...QueueConnOpt) *QueueConn {
 	c := &QueueConn{
 		Conn:       conn,
 		id:         atomic.AddUint64(&autoId, 1),
@@ -103,9 +168,20 @@ func NewQueueConn(conn Conn, opts ...QueueConnOpt) *QueueConn {
 		opt(c)
 	}
 	c.sendCh = make(chan protocol.Packet, c.sendChSize)
+	c.ctrlCh = make(chan protocol.Packet, c.sendChSize)
+	c.creditCh = make(chan struct{}, 1)
+	c.closing = make(chan struct{})
+	c.ctx, c.cancel = context.WithCancel(context.Background())
+	return c
+}
+
+// start starts the sending goroutine and receiving goroutine.
+func (c *QueueConn) start() {
+	if c.recvWindow > 0 {
+		c.Send(pkts.NewConnWindowPkt(c.recvWindow))
+	}
 	go c.sendLoop()
 	go c.receiveLoop()
-	return c
 }
 
 // PktHandler returns the packet handler.
@@ -118,6 +194,12 @@ func (c *QueueConn) Id() uint64 {
 	return c.id
 }
 
+// Context returns the context of the connection's lifetime,
+// it's cancelled when the connection starts closing.
+func (c *QueueConn) Context() context.Context {
+	return c.ctx
+}
+
 // IsClosed returns a bool indicating whether the connection has been closed.
 func (c *QueueConn) IsClosed() bool {
 	return atomic.LoadUint32(&c.closedFlag) != 0
@@ -125,30 +207,71 @@ func (c *QueueConn) IsClosed() bool {
 
 // Enqueue attempts to send a packet to the sending channel, it will returns an error
 // if the connection has been closed or the channel buffer is full.
-// Be careful that it will close the connection right away if the channel buffer is full.
+// Be careful that it will close the connection right away if the channel buffer is full,
+// unless the packets are waiting for the credits of the remote peer, in which case
+// ErrNoCredit is returned and the connection is kept.
 func (c *QueueConn) Send(p protocol.Packet) error {
 	if c.IsClosed() {
 		// Can't send message to the closed connection.
 		return ErrConnClosed
 	}
+	ch := c.chanOf(p)
 	select {
-	case c.sendCh <- p:
+	case ch <- p:
 		return nil
 	default:
-		// A slow consumer was detected, close the underlying connection right away.
-		c.Conn.Close()
-		// Mark the connection as closed.
-		c.markClosed()
-		return ErrSlowConsumer
 	}
+	if ch == c.sendCh && c.noCredit() {
+		// The remote peer is alive and slows down the sending by the flow control.
+		return ErrNoCredit
+	}
+	// A slow consumer was detected, close the underlying connection right away.
+	c.Conn.Close()
+	// Mark the connection as closed.
+	c.markClosed()
+	return ErrSlowConsumer
+}
+
+// contextSender is implemented by the connections that can wait for the space
+// of their sending queues, such as QueueConn.
+type contextSender interface {
+	SendContext(ctx context.Context, p protocol.Packet) error
+}
+
+// SendContext is like Send, but it waits for the space of the sending channel
+// if the channel buffer is full until the ctx is done.
+func (c *QueueConn) SendContext(ctx context.Context, p protocol.Packet) error {
+	if c.IsClosed() {
+		return ErrConnClosed
+	}
+	select {
+	case c.chanOf(p) <- p:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-c.ctx.Done():
+		return ErrConnClosed
+	}
+}
+
+// chanOf returns the channel to send the p.
+func (c *QueueConn) chanOf(p protocol.Packet) chan protocol.Packet {
+	if !flowControlled(p) && c.isLimited() {
+		return c.ctrlCh
+	}
+	return c.sendCh
 }
 
 // Close closes the connection after all pending packets in sender queue are sent.
+// The packets waiting for the credits of the remote peer are not sent.
 func (c *QueueConn) Close() error {
-	err := c.Send(nil)
+	if c.IsClosed() {
+		return ErrConnClosed
+	}
+	c.closeOnce.Do(func() { close(c.closing) })
 	// Mark the connection as closed.
 	c.markClosed()
-	return err
+	return nil
 }
 
 // CloseWith closes the connection after the given p is sent.
@@ -160,8 +283,12 @@ func (c *QueueConn) CloseWith(p protocol.Packet) error {
 func (c *QueueConn) sendLoop() {
 	var err error
 	for {
-		p := <-c.sendCh
-		if p == nil {
+		p, ok := c.next()
+		if !ok {
+			break
+		}
+		if err = c.acquire(p); err != nil {
+			c.unsent = p
 			break
 		}
 		err = c.Conn.Send(p)
@@ -175,6 +302,29 @@ func (c *QueueConn) sendLoop() {
 	c.close(err, false)
 }
 
+// next returns the next packet to send, the control packets have priority.
+// It returns false if the connection is closing and the sending channel is empty.
+func (c *QueueConn) next() (protocol.Packet, bool) {
+	select {
+	case p := <-c.ctrlCh:
+		return p, true
+	default:
+	}
+	select {
+	case p := <-c.ctrlCh:
+		return p, true
+	case p := <-c.sendCh:
+		return p, p != nil
+	case <-c.closing:
+	}
+	select {
+	case p := <-c.sendCh:
+		return p, p != nil
+	default:
+		return nil, false
+	}
+}
+
 func (c *QueueConn) receiveLoop() () {
 	var err error
 	for {
@@ -183,8 +333,14 @@ func (c *QueueConn) receiveLoop() () {
 		if err != nil {
 			break
 		}
+		if c.handleFlowPkt(p) {
+			continue
+		}
 		// handle message
 		c.pktHandler(p)
+		if !c.consumeAsync {
+			c.consume(p)
+		}
 	}
 	c.close(err, true)
 }
@@ -203,6 +359,7 @@ func (c *QueueConn) close(err error, isReadingErr bool) {
 		c.cause = err
 		// Mark the connection as closed.
 		c.markClosed()
+		c.cancel()
 		// Close the underlying connection right away.
 		// Situation 1:
 		// 		It will trigger the receiving goroutine to exit when the sending goroutine quits.
@@ -222,7 +379,7 @@ func (c *QueueConn) close(err error, isReadingErr bool) {
 		return
 	}
 	// Both the sending goroutine and reading goroutine have exit.
-	if c.pendingPktsHandler != nil && len(c.sendCh) > 0 {
+	if c.pendingPktsHandler != nil && (c.unsent != nil || len(c.sendCh) > 0) {
 		c.pendingPktsHandler(c.getPendingPackets())
 	}
 	if c.onClose != nil {
@@ -231,7 +388,10 @@ func (c *QueueConn) close(err error, isReadingErr bool) {
 }
 
 func (c *QueueConn) getPendingPackets() []protocol.Packet {
-	pkts := make([]protocol.Packet, 0, len(c.sendCh))
+	pkts := make([]protocol.Packet, 0, len(c.sendCh)+1)
+	if c.unsent != nil {
+		pkts = append(pkts, c.unsent)
+	}
 	for {
 		select {
 		case pkt := <-c.sendCh:
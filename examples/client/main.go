@@ -1,13 +1,13 @@
 package main
 
 import (
+	"context"
 	"github.com/happyxcj/gosocket"
 	"github.com/happyxcj/gosocket/protocol"
 	"github.com/happyxcj/gosocket/pkts"
 	"time"
 	"encoding/json"
 	"fmt"
-	"os"
 )
 
 func main() {
@@ -15,9 +15,7 @@ func main() {
 		gosocket.QCOptions(
 			gosocket.PktHandler(handlePkt),
 			gosocket.OnClose(func(e error) {
-				fmt.Println("current connection is closed, please subscribe to the services again")
-				// Just for test.
-				os.Exit(1)
+				fmt.Println("current connection is closed, the subscriptions will be restored after reconnecting")
 			})))
 	defer c.Close()
 	go pingLoop(c)
@@ -38,11 +36,6 @@ type Message struct {
 	Content string
 }
 
-type ErrResp struct {
-	Code int
-	Msg  string
-}
-
 func mockSend(c *gosocket.Client) {
 	notification := &Message{Id: 1, Content: "hello"}
 	data, _ := json.Marshal(notification)
@@ -55,29 +48,25 @@ func mockSend(c *gosocket.Client) {
 	subMsg := &Message{Id: 1, Content: "hello"}
 	data, _ = json.Marshal(subMsg)
 	subPkt := pkts.NewEasySubPkt(10, 1000, data)
-	pkt.Props().WithInt64(pkts.PropCreatedTime, time.Now().Unix())
-	c.Send(subPkt)
+	subPkt.Props().WithInt64(pkts.PropCreatedTime, time.Now().Unix())
+	_, resp, err := c.Subs().Subscribe(context.Background(), subPkt, handlePubPkt)
+	if st, ok := err.(*pkts.Status); ok {
+		fmt.Printf("unable to subscrbie service, request id is: %v, reason: %v\n", subPkt.SeqId(), st.Message)
+		return
+	}
+	if err != nil {
+		fmt.Println("unable to subscribe service: ", err)
+		return
+	}
+	handleSubPkt(resp)
 }
 
 func handlePkt(p protocol.Packet) {
-	switch v := p.(type) {
-	case *pkts.SubPkt:
-		handleSubPkt(v)
-	case *pkts.PubPkt:
-		handlePubPkt(v)
-	}
+	fmt.Println("receive packet: ", p.Desc())
 }
 
 func handleSubPkt(p *pkts.SubPkt) {
-	switch p.Cmd() {
-	case 1000:
-		fmt.Printf("subscribe '%v' successfully\n", p.Desc())
-	case 110:
-		appErr := &ErrResp{}
-		json.Unmarshal(p.Body(), appErr)
-		fmt.Printf("unable to subscrbie service, request id is: %v, reason: %v\n", p.SeqId(), appErr.Msg)
-	}
-
+	fmt.Printf("subscribe '%v' successfully\n", p.Desc())
 }
 
 func handlePubPkt(p *pkts.PubPkt) {
@@ -4,18 +4,24 @@ import (
 	"github.com/happyxcj/gosocket/route"
 	"github.com/happyxcj/gosocket/pkts"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"time"
 )
 
 func initHandlers() {
+	// Recover from the panics in all handlers.
+	route.Use(route.Recovery())
+	// Log the unknown packets and reply to the unknown requests.
+	route.NoRoute(handleNoRoute, route.NotFound)
+
 	route.Group(pkts.KindPing).Handle("ping", nil, handlePingResp)
 
-	route.Group(pkts.KindNotify).Use(handleMsgDecode).
+	// The messages are decoded into c.Msg and validated by the router.
+	route.Group(pkts.KindNotify).
 		Handle("1000", Message{}, handleNotifyMsg)
 
 	route.Group(pkts.KindSubscribe).
-		Use(handleReqDecode).
 		UseAfter(handleResp).
 		Handle("1000", Message{}, handleSubMsg)
 }
@@ -25,40 +31,21 @@ type Message struct {
 	Content string
 }
 
-func handlePingResp(c *route.Context) {
-	if !c.Pkt.Flags().Has(pkts.FlagPong) {
-		c.Conn.Send(pkts.NewPongPkt())
-	}
-}
-
-func handleMsgDecode(c *route.Context) {
-	pkt := c.Pkt.(pkts.DataPkt)
-	// The request packet has the application message.
-	err := json.Unmarshal(pkt.Body(), c.Msg)
-	if err == nil {
-		return
+// Validate implements the route.Validator, it's called by the router after decoding.
+func (m *Message) Validate() error {
+	if m.Content == "" {
+		return errors.New("empty content")
 	}
-	fmt.Printf("unable to decode message: %v, error: %v\n", pkt.Desc(), err)
-	c.Abort()
+	return nil
 }
 
-type ErrResp struct {
-	Code int
-	Msg  string
+func handleNoRoute(c *route.Context) {
+	fmt.Printf("no route for packet: %v, %v\n", c.Pkt.Kind(), route.GenMsgId(c.Pkt))
 }
 
-func handleReqDecode(c *route.Context) {
-	pkt := c.Pkt.(pkts.DataPkt)
-	err := json.Unmarshal(pkt.Body(), c.Msg)
-	if err != nil {
-		fmt.Printf("unable to decode message: %v, error: %v\n", pkt.Desc(), err)
-		resp := &ErrResp{Code: 111, Msg: "server is busy"}
-		// To ensure there must be a response to the client, we ignore the encoding result.
-		c.Msg, _ = json.Marshal(resp)
-		c.Pkt.(pkts.DataPkt).SetCmd(110)
-		handleResp(c)
-		c.Abort()
-		return
+func handlePingResp(c *route.Context) {
+	if !c.Pkt.Flags().Has(pkts.FlagPong) {
+		c.Conn.Send(pkts.NewPongPkt())
 	}
 }
 
@@ -80,15 +67,18 @@ func handleSubMsg(c *route.Context) {
 	c.Pkt.(*pkts.SubPkt).Props().WithStr(pkts.PropTopic, mockToipc)
 
 	// Mock to publish later messages.
+	// The context can't be used after the handler returns, so keep the connection.
+	conn := c.Conn
 	go func() {
 		for i := 0; i < 5; i++ {
 			time.Sleep(time.Second)
 			msg := &Message{Id: i, Content: fmt.Sprint("latest message ",i)}
 			data, _ := json.Marshal(msg)
 			pkt := pkts.NewEasyPubPkt(mockToipc,1000, data)
-			c.Conn.Send(pkt)
+			conn.Send(pkt)
 		}
 
-		panic("panic actively to test the closed connection")
+		// Close actively to test the closed connection.
+		conn.Close()
 	}()
 }
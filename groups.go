@@ -0,0 +1,125 @@
+package gosocket
+
+import (
+	"sync"
+
+	"github.com/happyxcj/gosocket/protocol"
+)
+
+// Groups is a registry of the named connection groups (e.g., rooms),
+// it's used to broadcast packets to all connections in a group.
+//
+// The closed connections must be removed by LeaveAll, which is done
+// automatically for the groups of a Server.
+type Groups struct {
+	mu sync.RWMutex
+	// groups contains the members of each group.
+	groups map[string]map[uint64]*QueueConn
+	// joined contains the joined groups of each connection.
+	joined map[uint64]map[string]struct{}
+}
+
+func NewGroups() *Groups {
+	return &Groups{
+		groups: make(map[string]map[uint64]*QueueConn),
+		joined: make(map[uint64]map[string]struct{}),
+	}
+}
+
+// Join adds the c to the named group.
+// It returns an error "ErrConnClosed" if the c has been closed.
+func (g *Groups) Join(name string, c *QueueConn) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	// Check it with the lock held, so the c is either rejected here
+	// or removed by the following LeaveAll.
+	if c.IsClosed() {
+		return ErrConnClosed
+	}
+	members, ok := g.groups[name]
+	if !ok {
+		members = make(map[uint64]*QueueConn)
+		g.groups[name] = members
+	}
+	members[c.Id()] = c
+	names, ok := g.joined[c.Id()]
+	if !ok {
+		names = make(map[string]struct{})
+		g.joined[c.Id()] = names
+	}
+	names[name] = struct{}{}
+	return nil
+}
+
+// Leave removes the c from the named group.
+func (g *Groups) Leave(name string, c *QueueConn) {
+	g.mu.Lock()
+	g.leave(name, c.Id())
+	if names := g.joined[c.Id()]; len(names) == 0 {
+		delete(g.joined, c.Id())
+	}
+	g.mu.Unlock()
+}
+
+// LeaveAll removes the c from all groups it joined.
+func (g *Groups) LeaveAll(c *QueueConn) {
+	g.mu.Lock()
+	for name := range g.joined[c.Id()] {
+		g.leave(name, c.Id())
+	}
+	delete(g.joined, c.Id())
+	g.mu.Unlock()
+}
+
+func (g *Groups) leave(name string, id uint64) {
+	members := g.groups[name]
+	delete(members, id)
+	if len(members) == 0 {
+		delete(g.groups, name)
+	}
+	delete(g.joined[id], name)
+}
+
+// Members returns all connections in the named group.
+func (g *Groups) Members(name string) []*QueueConn {
+	g.mu.RLock()
+	members := make([]*QueueConn, 0, len(g.groups[name]))
+	for _, c := range g.groups[name] {
+		members = append(members, c)
+	}
+	g.mu.RUnlock()
+	return members
+}
+
+// Joined returns the names of all groups the c joined.
+func (g *Groups) Joined(c *QueueConn) []string {
+	g.mu.RLock()
+	names := make([]string, 0, len(g.joined[c.Id()]))
+	for name := range g.joined[c.Id()] {
+		names = append(names, name)
+	}
+	g.mu.RUnlock()
+	return names
+}
+
+// Broadcast sends the p to all connections in the named group.
+// It returns the number of connections the p is sent to successfully.
+func (g *Groups) Broadcast(name string, p protocol.Packet) int {
+	return g.BroadcastExcept(name, p, nil)
+}
+
+// BroadcastExcept sends the p to all connections except the given except
+// (usually the sender) in the named group.
+// It returns the number of connections the p is sent to successfully.
+func (g *Groups) BroadcastExcept(name string, p protocol.Packet, except *QueueConn) int {
+	n := 0
+	for _, c := range g.Members(name) {
+		if c == except {
+			continue
+		}
+		if c.Send(p) == nil {
+			n++
+		}
+	}
+	return n
+}
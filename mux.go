@@ -0,0 +1,263 @@
+package gosocket
+
+import (
+	"errors"
+	"net"
+	"sync"
+
+	"github.com/happyxcj/gosocket/pkts"
+	"github.com/happyxcj/gosocket/protocol"
+)
+
+const (
+	defaultMuxWindow    = 256 << 10
+	defaultMuxFrameSize = 16 << 10
+	defaultMuxBacklog   = 64
+)
+
+var (
+	// ErrMuxClosed is returned when opening or accepting a stream on a closed mux.
+	ErrMuxClosed = errors.New("the mux has been closed")
+
+	// ErrStreamReset is returned when the stream has been reset by the peer.
+	ErrStreamReset = errors.New("the stream has been reset")
+)
+
+var (
+	_ Router       = (*Mux)(nil)
+	_ net.Listener = (*Mux)(nil)
+)
+
+// Mux multiplexes the bidirectional streams over a connection by StreamPkt.
+// Either peer can open a stream by Open, and the other one receives it by Accept.
+//
+// Each direction of a stream has its own flow-control window: the receiver advertises
+// its window when the stream is opened or accepted, and grants the consumed bytes
+// back to the sender when half of the window is read.
+type Mux struct {
+	conn Conn
+	opts *MuxOpts
+
+	mu      sync.Mutex
+	closed  bool
+	streams map[uint32]*Stream
+	// nextId is the id of the next stream opened by the local peer,
+	// the client side uses the odd ids and the server side uses the even ones.
+	nextId uint32
+
+	acceptCh chan *Stream
+	done     chan struct{}
+}
+
+type MuxOpts struct {
+	// window is the receiving window in bytes of every stream.
+	// It's default value is 256KB.
+	window uint32
+	// frameSize is the max size of the data carried by a StreamPkt.
+	// It's default value is 16KB.
+	frameSize int
+	// backlog is the max number of the streams waiting to be accepted,
+	// the other opened streams are reset.
+	// It's default value is 64.
+	backlog int
+	// onAccept is called in a new goroutine for every stream opened by the peer
+	// instead of delivering it to Accept.
+	onAccept func(s *Stream)
+}
+
+// MuxOpt specifies an option for a mux.
+type MuxOpt func(*MuxOpts)
+
+// MuxWindow returns a MuxOpt to set the receiving window in bytes of every stream.
+func MuxWindow(n uint32) MuxOpt {
+	return func(o *MuxOpts) {
+		o.window = n
+	}
+}
+
+// MuxFrameSize returns a MuxOpt to set the max size of the data carried by a StreamPkt.
+func MuxFrameSize(n int) MuxOpt {
+	return func(o *MuxOpts) {
+		o.frameSize = n
+	}
+}
+
+// MuxBacklog returns a MuxOpt to set the max number of the streams waiting to be accepted.
+func MuxBacklog(n int) MuxOpt {
+	return func(o *MuxOpts) {
+		o.backlog = n
+	}
+}
+
+// OnAccept returns a MuxOpt to set the callback for every stream opened by the peer,
+// it's called in a new goroutine and Accept is not used anymore.
+func OnAccept(onAccept func(*Stream)) MuxOpt {
+	return func(o *MuxOpts) {
+		o.onAccept = onAccept
+	}
+}
+
+// NewMux returns a Mux over the conn, the client indicates which side the conn is.
+// The StreamPkts received from the conn must be passed to the HandlePacket of the mux,
+// and the mux should be closed when the conn is closed.
+func NewMux(conn Conn, client bool, opts ...MuxOpt) *Mux {
+	m := &Mux{
+		conn: conn,
+		opts: &MuxOpts{
+			window:    defaultMuxWindow,
+			frameSize: defaultMuxFrameSize,
+			backlog:   defaultMuxBacklog,
+		},
+		streams: make(map[uint32]*Stream),
+		nextId:  2,
+		done:    make(chan struct{}),
+	}
+	if client {
+		m.nextId = 1
+	}
+	for _, opt := range opts {
+		opt(m.opts)
+	}
+	m.acceptCh = make(chan *Stream, m.opts.backlog)
+	return m
+}
+
+// Open opens a new stream to the peer.
+// The data can't be written until the peer accepts the stream and advertises its window.
+func (m *Mux) Open() (*Stream, error) {
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return nil, ErrMuxClosed
+	}
+	id := m.nextId
+	m.nextId += 2
+	s := newStream(m, id, 0)
+	m.streams[id] = s
+	m.mu.Unlock()
+	if err := m.conn.Send(pkts.NewStreamWindowPkt(id, pkts.FlagStreamOpen, m.opts.window)); err != nil {
+		m.remove(id)
+		return nil, err
+	}
+	return s, nil
+}
+
+// AcceptStream waits for and returns the next stream opened by the peer.
+func (m *Mux) AcceptStream() (*Stream, error) {
+	select {
+	case s := <-m.acceptCh:
+		return s, nil
+	case <-m.done:
+		return nil, ErrMuxClosed
+	}
+}
+
+// Accept waits for and returns the next stream opened by the peer as a net.Conn.
+func (m *Mux) Accept() (net.Conn, error) {
+	s, err := m.AcceptStream()
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Addr returns the address of the mux.
+func (m *Mux) Addr() net.Addr {
+	return muxAddr{}
+}
+
+// Close closes the mux and aborts all streams without notifying the peer.
+// The underlying connection is not closed.
+func (m *Mux) Close() error {
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return nil
+	}
+	m.closed = true
+	streams := m.streams
+	m.streams = make(map[uint32]*Stream)
+	close(m.done)
+	m.mu.Unlock()
+	for _, s := range streams {
+		s.abort(ErrMuxClosed)
+	}
+	return nil
+}
+
+// HandlePacket handles the StreamPkt received from the connection of the m.
+func (m *Mux) HandlePacket(c Conn, p protocol.Packet) bool {
+	pkt, ok := p.(*pkts.StreamPkt)
+	if !ok || c != m.conn {
+		return false
+	}
+	id, flags := pkt.StreamId(), pkt.Flags()
+	if flags.Has(pkts.FlagStreamOpen) {
+		m.accept(id, pkt.Window())
+		return true
+	}
+	m.mu.Lock()
+	s, ok := m.streams[id]
+	m.mu.Unlock()
+	if !ok {
+		// The stream has been closed locally.
+		return true
+	}
+	switch {
+	case flags.Has(pkts.FlagStreamReset):
+		m.remove(id)
+		s.abort(ErrStreamReset)
+	case flags.Has(pkts.FlagStreamWindow):
+		s.grant(pkt.Window())
+	case flags.Has(pkts.FlagStreamClose):
+		m.remove(id)
+		s.closeRemote()
+	default:
+		if !s.push(pkt.Body()) {
+			// The peer sends more data than the window.
+			m.remove(id)
+			s.abort(ErrStreamReset)
+			m.conn.Send(pkts.NewStreamPkt(id, pkts.FlagStreamReset))
+		}
+	}
+	return true
+}
+
+// accept accepts a stream opened by the peer with the given sending window.
+func (m *Mux) accept(id, window uint32) {
+	m.mu.Lock()
+	_, exists := m.streams[id]
+	if m.closed || exists || id%2 == m.nextId%2 {
+		m.mu.Unlock()
+		m.conn.Send(pkts.NewStreamPkt(id, pkts.FlagStreamReset))
+		return
+	}
+	s := newStream(m, id, window)
+	if m.opts.onAccept == nil {
+		select {
+		case m.acceptCh <- s:
+		default:
+			m.mu.Unlock()
+			m.conn.Send(pkts.NewStreamPkt(id, pkts.FlagStreamReset))
+			return
+		}
+	}
+	m.streams[id] = s
+	m.mu.Unlock()
+	m.conn.Send(pkts.NewStreamWindowPkt(id, pkts.FlagStreamWindow, m.opts.window))
+	if m.opts.onAccept != nil {
+		go m.opts.onAccept(s)
+	}
+}
+
+func (m *Mux) remove(id uint32) {
+	m.mu.Lock()
+	delete(m.streams, id)
+	m.mu.Unlock()
+}
+
+// muxAddr is the address of the mux and its streams.
+type muxAddr struct{}
+
+func (muxAddr) Network() string { return "gosocket" }
+func (muxAddr) String() string  { return "mux" }
@@ -0,0 +1,362 @@
+package gosocket
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/happyxcj/gosocket/pkts"
+	"github.com/happyxcj/gosocket/protocol"
+)
+
+var _ net.Conn = (*Stream)(nil)
+
+// Stream is a bidirectional stream multiplexed by a Mux, it implements net.Conn.
+type Stream struct {
+	m  *Mux
+	id uint32
+
+	mu sync.Mutex
+	// buf contains the received data not read yet.
+	buf bytes.Buffer
+	// unacked is the number of the read bytes not granted to the peer.
+	unacked uint32
+	// sendWindow is the number of the bytes can be sent to the peer.
+	sendWindow uint32
+	// readErr is returned by Read after the buffered data is read.
+	readErr error
+	// writeErr is returned by Write.
+	writeErr error
+	// closed indicates whether the stream is closed or aborted.
+	closed bool
+	// done is closed when the stream is closed or aborted.
+	done chan struct{}
+
+	// readCh and writeCh notify the blocked Read and Write.
+	readCh  chan struct{}
+	writeCh chan struct{}
+
+	readDeadline  pipeDeadline
+	writeDeadline pipeDeadline
+}
+
+func newStream(m *Mux, id, sendWindow uint32) *Stream {
+	return &Stream{
+		m:             m,
+		id:            id,
+		sendWindow:    sendWindow,
+		readCh:        make(chan struct{}, 1),
+		writeCh:       make(chan struct{}, 1),
+		done:          make(chan struct{}),
+		readDeadline:  makePipeDeadline(),
+		writeDeadline: makePipeDeadline(),
+	}
+}
+
+// Id returns the id of the stream.
+func (s *Stream) Id() uint32 {
+	return s.id
+}
+
+// Read reads the data sent by the peer. It returns io.EOF after the peer
+// closes the stream and all data is read.
+func (s *Stream) Read(b []byte) (int, error) {
+	for {
+		if isClosedChan(s.readDeadline.wait()) {
+			return 0, os.ErrDeadlineExceeded
+		}
+		s.mu.Lock()
+		if s.buf.Len() > 0 {
+			n, _ := s.buf.Read(b)
+			s.unacked += uint32(n)
+			var grant uint32
+			if s.readErr == nil && s.unacked >= s.m.opts.window/2 {
+				grant, s.unacked = s.unacked, 0
+			}
+			more := s.buf.Len() > 0
+			s.mu.Unlock()
+			if more {
+				// Wake up the other blocked Read.
+				signal(s.readCh)
+			}
+			if grant > 0 {
+				s.m.conn.Send(pkts.NewStreamWindowPkt(s.id, pkts.FlagStreamWindow, grant))
+			}
+			return n, nil
+		}
+		if err := s.readErr; err != nil {
+			s.mu.Unlock()
+			// Wake up the other blocked Read.
+			signal(s.readCh)
+			return 0, err
+		}
+		s.mu.Unlock()
+		select {
+		case <-s.readCh:
+		case <-s.readDeadline.wait():
+			return 0, os.ErrDeadlineExceeded
+		}
+	}
+}
+
+// Write writes the data to the peer, it waits if the window of the peer is used up
+// or the sending queue of the connection is full.
+func (s *Stream) Write(b []byte) (int, error) {
+	n := 0
+	var ctx context.Context
+	for n < len(b) {
+		if isClosedChan(s.writeDeadline.wait()) {
+			return n, os.ErrDeadlineExceeded
+		}
+		s.mu.Lock()
+		if err := s.writeErr; err != nil {
+			s.mu.Unlock()
+			// Wake up the other blocked Write.
+			signal(s.writeCh)
+			return n, err
+		}
+		if s.sendWindow == 0 {
+			s.mu.Unlock()
+			select {
+			case <-s.writeCh:
+			case <-s.writeDeadline.wait():
+				return n, os.ErrDeadlineExceeded
+			}
+			continue
+		}
+		size := len(b) - n
+		if size > s.m.opts.frameSize {
+			size = s.m.opts.frameSize
+		}
+		if uint32(size) > s.sendWindow {
+			size = int(s.sendWindow)
+		}
+		s.sendWindow -= uint32(size)
+		s.mu.Unlock()
+		// The packet is sent asynchronously, so the data must be copied.
+		data := make([]byte, size)
+		copy(data, b[n:n+size])
+		if ctx == nil {
+			var cancel context.CancelFunc
+			ctx, cancel = s.writeContext()
+			defer cancel()
+		}
+		if err := s.send(ctx, pkts.NewStreamDataPkt(s.id, data)); err != nil {
+			return n, err
+		}
+		n += size
+	}
+	return n, nil
+}
+
+// writeContext returns the context of a Write, it's done when the write deadline
+// is exceeded, or the stream or the mux is closed.
+func (s *Stream) writeContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	deadline := s.writeDeadline.wait()
+	go func() {
+		select {
+		case <-deadline:
+		case <-s.done:
+		case <-s.m.done:
+		case <-ctx.Done():
+		}
+		cancel()
+	}()
+	return ctx, cancel
+}
+
+// send sends the p by the connection of the mux. It waits for the space of the
+// sending queue until the ctx is done if the connection supports it, so the
+// connection is not closed as a slow consumer by the writes of the streams.
+func (s *Stream) send(ctx context.Context, p protocol.Packet) error {
+	cs, ok := s.m.conn.(contextSender)
+	if !ok {
+		return s.m.conn.Send(p)
+	}
+	err := cs.SendContext(ctx, p)
+	if err == nil || ctx.Err() == nil {
+		return err
+	}
+	if isClosedChan(s.writeDeadline.wait()) {
+		return os.ErrDeadlineExceeded
+	}
+	s.mu.Lock()
+	err = s.writeErr
+	s.mu.Unlock()
+	if err == nil {
+		err = ErrMuxClosed
+	}
+	return err
+}
+
+// Close closes the stream and notifies the peer, the unread data is discarded.
+func (s *Stream) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	close(s.done)
+	s.buf.Reset()
+	s.readErr, s.writeErr = ErrStreamClosed, ErrStreamClosed
+	s.mu.Unlock()
+	s.notify()
+	s.m.remove(s.id)
+	return s.m.conn.Send(pkts.NewStreamPkt(s.id, pkts.FlagStreamClose))
+}
+
+func (s *Stream) LocalAddr() net.Addr {
+	return muxAddr{}
+}
+
+func (s *Stream) RemoteAddr() net.Addr {
+	return muxAddr{}
+}
+
+func (s *Stream) SetDeadline(t time.Time) error {
+	s.readDeadline.set(t)
+	s.writeDeadline.set(t)
+	return nil
+}
+
+func (s *Stream) SetReadDeadline(t time.Time) error {
+	s.readDeadline.set(t)
+	return nil
+}
+
+func (s *Stream) SetWriteDeadline(t time.Time) error {
+	s.writeDeadline.set(t)
+	return nil
+}
+
+// push buffers the data received from the peer.
+// It returns false if the data exceeds the receiving window.
+func (s *Stream) push(data []byte) bool {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return true
+	}
+	if uint64(s.buf.Len())+uint64(s.unacked)+uint64(len(data)) > uint64(s.m.opts.window) {
+		s.mu.Unlock()
+		return false
+	}
+	s.buf.Write(data)
+	s.mu.Unlock()
+	s.notify()
+	return true
+}
+
+// grant increases the sending window by the n.
+func (s *Stream) grant(n uint32) {
+	s.mu.Lock()
+	s.sendWindow += n
+	s.mu.Unlock()
+	s.notify()
+}
+
+// closeRemote is called when the peer closes the stream,
+// Read returns io.EOF after the buffered data is read.
+func (s *Stream) closeRemote() {
+	s.mu.Lock()
+	if s.readErr == nil {
+		s.readErr = io.EOF
+	}
+	if s.writeErr == nil {
+		s.writeErr = ErrStreamClosed
+	}
+	s.mu.Unlock()
+	s.notify()
+}
+
+// abort fails the stream with the err without notifying the peer.
+func (s *Stream) abort(err error) {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return
+	}
+	s.closed = true
+	close(s.done)
+	s.buf.Reset()
+	s.readErr, s.writeErr = err, err
+	s.mu.Unlock()
+	s.notify()
+}
+
+func (s *Stream) notify() {
+	signal(s.readCh)
+	signal(s.writeCh)
+}
+
+func signal(c chan struct{}) {
+	select {
+	case c <- struct{}{}:
+	default:
+	}
+}
+
+// pipeDeadline is an abstraction for handling timeouts, like the one of net.Pipe.
+type pipeDeadline struct {
+	mu     sync.Mutex
+	timer  *time.Timer
+	cancel chan struct{}
+}
+
+func makePipeDeadline() pipeDeadline {
+	return pipeDeadline{cancel: make(chan struct{})}
+}
+
+// set sets the point in time when the deadline will time out,
+// a zero value for t disables the deadline.
+func (d *pipeDeadline) set(t time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.timer != nil && !d.timer.Stop() {
+		// Wait for the timer callback to finish and close the cancel.
+		<-d.cancel
+	}
+	d.timer = nil
+	closed := isClosedChan(d.cancel)
+	if t.IsZero() {
+		if closed {
+			d.cancel = make(chan struct{})
+		}
+		return
+	}
+	if dur := time.Until(t); dur > 0 {
+		if closed {
+			d.cancel = make(chan struct{})
+		}
+		cancel := d.cancel
+		d.timer = time.AfterFunc(dur, func() {
+			close(cancel)
+		})
+		return
+	}
+	if !closed {
+		close(d.cancel)
+	}
+}
+
+// wait returns a channel that is closed when the deadline is exceeded.
+func (d *pipeDeadline) wait() chan struct{} {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.cancel
+}
+
+func isClosedChan(c <-chan struct{}) bool {
+	select {
+	case <-c:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,60 @@
+package gosocket
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/happyxcj/gosocket/protocol"
+)
+
+// idleConn is a Conn that never sends or receives a packet.
+type idleConn struct{}
+
+func (idleConn) Send(p protocol.Packet) error      { return nil }
+func (idleConn) Receive() (protocol.Packet, error) { select {} }
+func (idleConn) Close() error                      { return nil }
+
+// newFullStream returns a stream whose connection never drains its sending queue.
+func newFullStream() (*QueueConn, *Stream) {
+	qc := newQueueConn(idleConn{}, SendChSize(1))
+	m := NewMux(qc, true, MuxFrameSize(16))
+	return qc, newStream(m, 1, 1<<20)
+}
+
+func TestStreamWriteDeadline(t *testing.T) {
+	qc, s := newFullStream()
+	s.SetWriteDeadline(time.Now().Add(20 * time.Millisecond))
+	n, err := s.Write(make([]byte, 64))
+	if netErr, ok := err.(net.Error); !ok || !netErr.Timeout() {
+		t.Fatalf("expect a timeout error, got %v", err)
+	}
+	if n != 16 {
+		t.Fatalf("expect 16 bytes written, got %d", n)
+	}
+	if qc.IsClosed() {
+		t.Fatal("the connection is closed by a blocked write")
+	}
+}
+
+func TestStreamWriteClose(t *testing.T) {
+	qc, s := newFullStream()
+	errCh := make(chan error, 1)
+	go func() {
+		_, err := s.Write(make([]byte, 64))
+		errCh <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	s.abort(ErrMuxClosed)
+	select {
+	case err := <-errCh:
+		if err != ErrMuxClosed {
+			t.Fatalf("expect %v, got %v", ErrMuxClosed, err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the write is not cancelled by closing the stream")
+	}
+	if qc.IsClosed() {
+		t.Fatal("the connection is closed by a blocked write")
+	}
+}
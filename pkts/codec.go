@@ -0,0 +1,98 @@
+package pkts
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// body codec modes
+const (
+	CodecJSON byte = 0
+)
+
+var (
+	ErrInvalidCodec = errors.New("invalid codec mode")
+
+	bodyCodecs = make(map[byte]BodyCodec)
+)
+
+// BodyCodec describes how to encode and decode the application message of a data packet.
+type BodyCodec interface {
+	// Marshal returns the encoding of the v.
+	Marshal(v interface{}) ([]byte, error)
+	// Unmarshal decodes the data and stores the result in the value pointed to by the v.
+	Unmarshal(data []byte, v interface{}) error
+}
+
+func init() {
+	RegisterBodyCodec(CodecJSON, jsonCodec{})
+}
+
+// RegisterBodyCodec registers a specified body codec based on the codec mode.
+// It will panic if the given codec mode had exist.
+func RegisterBodyCodec(codec byte, bc BodyCodec) {
+	if _, ok := bodyCodecs[codec]; ok {
+		panic(fmt.Sprintf("the given codec '%v' had exist", codec))
+	}
+	bodyCodecs[codec] = bc
+}
+
+// DeleteBodyCodecs unregisters the body codecs based on the given codec modes
+// or all body codecs if len(codecs) is zero.
+func DeleteBodyCodecs(codecs ...byte) {
+	if len(codecs) == 0 {
+		bodyCodecs = make(map[byte]BodyCodec)
+		return
+	}
+	for _, codec := range codecs {
+		delete(bodyCodecs, codec)
+	}
+}
+
+// FindBodyCodec returns the body codec based on the codec mode,
+// if the codec mode is invalid, it returns an error "ErrInvalidCodec".
+func FindBodyCodec(codec byte) (BodyCodec, error) {
+	if bc, ok := bodyCodecs[codec]; ok {
+		return bc, nil
+	}
+	return nil, ErrInvalidCodec
+}
+
+// EncodeBody encodes the v by the codec of the p and sets the result as the body of the p.
+func EncodeBody(p DataPkt, v interface{}) error {
+	bc, err := FindBodyCodec(p.Codec())
+	if err != nil {
+		return err
+	}
+	body, err := bc.Marshal(v)
+	if err != nil {
+		return err
+	}
+	p.SetBody(body)
+	return nil
+}
+
+// DecodeBody decodes the body of the p by the codec of the p,
+// and stores the result in the value pointed to by the v.
+// It does nothing if the p has no body.
+func DecodeBody(p DataPkt, v interface{}) error {
+	if len(p.Body()) == 0 {
+		return nil
+	}
+	bc, err := FindBodyCodec(p.Codec())
+	if err != nil {
+		return err
+	}
+	return bc.Unmarshal(p.Body(), v)
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
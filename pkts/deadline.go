@@ -0,0 +1,26 @@
+package pkts
+
+import (
+	"time"
+)
+
+// SetDeadline sets the deadline of the request p by the property "PropDeadline",
+// which is the unix time in milliseconds. A zero t removes the deadline.
+//
+// The deadline is an absolute time, so the clocks of both peers should be synchronized.
+func SetDeadline(p DataPkt, t time.Time) {
+	if t.IsZero() {
+		p.Props().Remove(PropDeadline)
+		return
+	}
+	p.Props().WithUint64(PropDeadline, uint64(t.UnixMilli()))
+}
+
+// GetDeadline returns the deadline of the request p if it exists.
+func GetDeadline(p DataPkt) (time.Time, bool) {
+	ms, ok := p.Props().GetUint64(PropDeadline)
+	if !ok {
+		return time.Time{}, false
+	}
+	return time.UnixMilli(int64(ms)), true
+}
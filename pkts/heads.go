@@ -38,7 +38,7 @@ func (h *NotifyHead) DecodeHead(r *protocol.Reader) error {
 	h.cmd = r.Uint16()
 	vc := r.Byte()
 	h.version = vc >> CodecBits
-	h.codec = vc & 0x08
+	h.codec = vc & (1<<CodecBits - 1)
 	size := int(r.Uint16())
 	h.props.Reset()
 	return h.props.Decode(r, size)
@@ -13,14 +13,38 @@ const (
 	KindSubscribe
 	KindUnsubscribe
 	KindPublish
+	KindCancel
+	KindWindow
+	KindStream
+	KindGoAway
 )
 
 // packet flags
 const (
 	FlagNo   protocol.PktFlags = 0
 	FlagPong protocol.PktFlags = 0x01
+	// FlagMore indicates more responses of the same request will follow.
+	FlagMore protocol.PktFlags = 0x02
+	// FlagStream indicates the request expects a stream of responses.
+	FlagStream protocol.PktFlags = 0x04
 )
 
+// the flags of the StreamPkt, a StreamPkt without any flag carries the data.
+const (
+	// FlagStreamOpen opens a stream and advertises the receiving window.
+	FlagStreamOpen protocol.PktFlags = 0x01
+	// FlagStreamClose closes a stream gracefully.
+	FlagStreamClose protocol.PktFlags = 0x02
+	// FlagStreamReset aborts a stream.
+	FlagStreamReset protocol.PktFlags = 0x04
+	// FlagStreamWindow increases the receiving window.
+	FlagStreamWindow protocol.PktFlags = 0x08
+)
+
+// FlagConnWindow indicates the WindowPkt grants the credits of the connection
+// instead of a streaming request, its sequence id is ignored.
+const FlagConnWindow protocol.PktFlags = 0x01
+
 
 func init() {
 	protocol.RegisterPktCreator(KindPing, func(b *protocol.PktBase) protocol.Packet {
@@ -43,6 +67,18 @@ func init() {
 	protocol.RegisterPktCreator(KindPublish, func(b *protocol.PktBase) protocol.Packet {
 		return NewPubPkt(b)
 	})
+	protocol.RegisterPktCreator(KindCancel, func(b *protocol.PktBase) protocol.Packet {
+		return &CancelPkt{PktBase: b}
+	})
+	protocol.RegisterPktCreator(KindWindow, func(b *protocol.PktBase) protocol.Packet {
+		return &WindowPkt{PktBase: b}
+	})
+	protocol.RegisterPktCreator(KindStream, func(b *protocol.PktBase) protocol.Packet {
+		return &StreamPkt{PktBase: b}
+	})
+	protocol.RegisterPktCreator(KindGoAway, func(b *protocol.PktBase) protocol.Packet {
+		return &GoAwayPkt{PktBase: b}
+	})
 }
 
 // DataPkt represents a packet that has the application message.
@@ -78,6 +114,48 @@ type ReqRespPkt interface {
 	SetSeqId(seqId uint16)
 }
 
+// NewRespPkt returns an empty response packet for the req, it has the same
+// kind, sequence id, cmd, version and codec as the req.
+func NewRespPkt(req ReqRespPkt) (ReqRespPkt, error) {
+	p, err := protocol.FindPacket(req.Kind(), FlagNo)
+	if err != nil {
+		return nil, err
+	}
+	resp, ok := p.(ReqRespPkt)
+	if !ok {
+		return nil, protocol.ErrInvalidPktKind
+	}
+	resp.SetSeqId(req.SeqId())
+	resp.SetCmd(req.Cmd())
+	resp.SetVersion(req.Version())
+	resp.SetCodec(req.Codec())
+	return resp, nil
+}
+
+// CloneReq returns a copy of the req with the same flags, properties and body,
+// the body is shared. The copy can be sent while the req is still being sent.
+func CloneReq(req ReqRespPkt) (ReqRespPkt, error) {
+	p, err := protocol.FindPacket(req.Kind(), req.Flags())
+	if err != nil {
+		return nil, err
+	}
+	clone, ok := p.(ReqRespPkt)
+	if !ok {
+		return nil, protocol.ErrInvalidPktKind
+	}
+	clone.SetSeqId(req.SeqId())
+	clone.SetCmd(req.Cmd())
+	clone.SetVersion(req.Version())
+	clone.SetCodec(req.Codec())
+	props := NewProps()
+	req.Props().ForEach(func(id PropID, prop Prop) {
+		props.With(id, prop)
+	})
+	clone.SetProps(props)
+	clone.SetBody(req.Body())
+	return clone, nil
+}
+
 var _ protocol.Packet = (*PingPkt)(nil)
 
 type PingPkt struct {
@@ -266,6 +344,242 @@ func NewFullPubPkt(topic string, cmd uint16, version, codec byte, body []byte) *
 	return p
 }
 
+// Clone returns a copy of the p, the properties of the copy can be modified
+// independently, but the body is shared.
+func (p *PubPkt) Clone() *PubPkt {
+	clone := NewFullPubPkt(p.topic, p.cmd, p.version, p.codec, p.Body())
+	p.props.ForEach(func(id PropID, prop Prop) {
+		clone.props.With(id, prop)
+	})
+	return clone
+}
+
 func (p *PubPkt) Desc() string {
 	return fmt.Sprintf("Publish:%v:%v", p.cmd, p.version)
 }
+
+var _ protocol.Packet = (*CancelPkt)(nil)
+
+// CancelPkt notifies the remote peer that the request with the sequence id
+// is cancelled, so the remote peer can stop handling it early.
+type CancelPkt struct {
+	*protocol.PktBase
+	seqId uint16
+}
+
+func NewCancelPkt(seqId uint16) *CancelPkt {
+	return &CancelPkt{PktBase: protocol.NewPktBase(KindCancel, FlagNo), seqId: seqId}
+}
+
+// SeqId returns the sequence id of the cancelled request.
+func (p *CancelPkt) SeqId() uint16 {
+	return p.seqId
+}
+
+func (p *CancelPkt) Desc() string {
+	return fmt.Sprintf("Cancel:%v", p.seqId)
+}
+
+func (p *CancelPkt) HeadSize() int {
+	// 2Bytes(SeqId)
+	return 2
+}
+
+func (p *CancelPkt) EncodeHead(w *protocol.Writer) {
+	w.PutUint16(p.seqId)
+}
+
+func (p *CancelPkt) DecodeHead(r *protocol.Reader) error {
+	if !r.HasSize(2) {
+		return protocol.ErrDecodeBadPacket
+	}
+	p.seqId = r.Uint16()
+	return nil
+}
+
+var _ protocol.Packet = (*WindowPkt)(nil)
+
+// WindowPkt grants the remote peer the credits to send more responses
+// of the streaming request with the sequence id, or more packets on the
+// connection with the flag "FlagConnWindow".
+type WindowPkt struct {
+	*protocol.PktBase
+	seqId   uint16
+	credits uint32
+}
+
+func NewWindowPkt(seqId uint16, credits uint32) *WindowPkt {
+	return &WindowPkt{PktBase: protocol.NewPktBase(KindWindow, FlagNo), seqId: seqId, credits: credits}
+}
+
+// NewConnWindowPkt returns a WindowPkt to grant the remote peer the credits
+// to send more packets on the connection.
+func NewConnWindowPkt(credits uint32) *WindowPkt {
+	return &WindowPkt{PktBase: protocol.NewPktBase(KindWindow, FlagConnWindow), credits: credits}
+}
+
+// SeqId returns the sequence id of the streaming request.
+func (p *WindowPkt) SeqId() uint16 {
+	return p.seqId
+}
+
+// Credits returns the number of the granted credits.
+func (p *WindowPkt) Credits() uint32 {
+	return p.credits
+}
+
+func (p *WindowPkt) Desc() string {
+	return fmt.Sprintf("Window:%v:%v", p.seqId, p.credits)
+}
+
+func (p *WindowPkt) HeadSize() int {
+	// 2Bytes(SeqId)+4Bytes(Credits)
+	return 6
+}
+
+func (p *WindowPkt) EncodeHead(w *protocol.Writer) {
+	w.PutUint16(p.seqId)
+	w.PutUint32(p.credits)
+}
+
+func (p *WindowPkt) DecodeHead(r *protocol.Reader) error {
+	if !r.HasSize(6) {
+		return protocol.ErrDecodeBadPacket
+	}
+	p.seqId = r.Uint16()
+	p.credits = r.Uint32()
+	return nil
+}
+
+var _ protocol.Packet = (*StreamPkt)(nil)
+
+// StreamPkt carries the data or the control message of a multiplexed stream.
+type StreamPkt struct {
+	*protocol.PktBase
+	streamId uint32
+	// window is the receiving window or its increment,
+	// it's available only with the flag "FlagStreamOpen" or "FlagStreamWindow".
+	window uint32
+}
+
+func NewStreamPkt(streamId uint32, flags protocol.PktFlags) *StreamPkt {
+	return &StreamPkt{PktBase: protocol.NewPktBase(KindStream, flags), streamId: streamId}
+}
+
+// NewStreamDataPkt returns a StreamPkt carrying the data.
+func NewStreamDataPkt(streamId uint32, data []byte) *StreamPkt {
+	p := NewStreamPkt(streamId, FlagNo)
+	p.SetBody(data)
+	return p
+}
+
+// NewStreamWindowPkt returns a StreamPkt with the flags and the window,
+// the flags should contain "FlagStreamOpen" or "FlagStreamWindow".
+func NewStreamWindowPkt(streamId uint32, flags protocol.PktFlags, window uint32) *StreamPkt {
+	p := NewStreamPkt(streamId, flags)
+	p.window = window
+	return p
+}
+
+// StreamId returns the id of the stream.
+func (p *StreamPkt) StreamId() uint32 {
+	return p.streamId
+}
+
+// Window returns the receiving window or its increment.
+func (p *StreamPkt) Window() uint32 {
+	return p.window
+}
+
+func (p *StreamPkt) hasWindow() bool {
+	return p.Flags()&(FlagStreamOpen|FlagStreamWindow) != 0
+}
+
+func (p *StreamPkt) Desc() string {
+	return fmt.Sprintf("Stream:%v:%v", p.streamId, p.Flags())
+}
+
+func (p *StreamPkt) HeadSize() int {
+	// 4Bytes(StreamId)+[4Bytes(Window)]
+	if p.hasWindow() {
+		return 8
+	}
+	return 4
+}
+
+func (p *StreamPkt) EncodeHead(w *protocol.Writer) {
+	w.PutUint32(p.streamId)
+	if p.hasWindow() {
+		w.PutUint32(p.window)
+	}
+}
+
+func (p *StreamPkt) DecodeHead(r *protocol.Reader) error {
+	if !r.HasSize(p.HeadSize()) {
+		return protocol.ErrDecodeBadPacket
+	}
+	p.streamId = r.Uint32()
+	if p.hasWindow() {
+		p.window = r.Uint32()
+	}
+	return nil
+}
+
+var _ protocol.Packet = (*GoAwayPkt)(nil)
+
+// GoAwayPkt tells the remote peer to stop sending new requests on the connection,
+// the in-flight requests are still finished.
+type GoAwayPkt struct {
+	*protocol.PktBase
+	reason string
+	// addr is the optional address to redirect the new requests to.
+	addr string
+}
+
+func NewGoAwayPkt(reason, addr string) *GoAwayPkt {
+	return &GoAwayPkt{PktBase: protocol.NewPktBase(KindGoAway, FlagNo), reason: reason, addr: addr}
+}
+
+// Reason returns the reason why the connection is going away.
+func (p *GoAwayPkt) Reason() string {
+	return p.reason
+}
+
+// Addr returns the address to redirect the new requests to,
+// it's empty if there is no redirection.
+func (p *GoAwayPkt) Addr() string {
+	return p.addr
+}
+
+func (p *GoAwayPkt) Desc() string {
+	return fmt.Sprintf("GoAway:%v:%v", p.reason, p.addr)
+}
+
+func (p *GoAwayPkt) HeadSize() int {
+	// 2Bytes(ReasonLen)+Reason+2Bytes(AddrLen)+Addr
+	return 4 + len(p.reason) + len(p.addr)
+}
+
+func (p *GoAwayPkt) EncodeHead(w *protocol.Writer) {
+	w.PutUint16(uint16(len(p.reason)))
+	w.PutString(p.reason)
+	w.PutUint16(uint16(len(p.addr)))
+	w.PutString(p.addr)
+}
+
+func (p *GoAwayPkt) DecodeHead(r *protocol.Reader) error {
+	if !r.HasSize(2) {
+		return protocol.ErrDecodeBadPacket
+	}
+	n := int(r.Uint16())
+	if !r.HasSize(n + 2) {
+		return protocol.ErrDecodeBadPacket
+	}
+	p.reason = r.String(n)
+	n = int(r.Uint16())
+	if !r.HasSize(n) {
+		return protocol.ErrDecodeBadPacket
+	}
+	p.addr = r.String(n)
+	return nil
+}
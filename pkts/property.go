@@ -11,6 +11,14 @@ const (
 	PropCreatedTime = 1
 	PropSubId       = 2
 	PropTopic       = 3
+	PropClientId    = 4
+	PropSeq         = 5
+	PropSinceSeq    = 6
+	PropSinceTime   = 7 // Unix seconds
+	PropErrMsg      = 8
+	PropStatus      = 9
+	PropDeadline    = 10
+	PropWindow      = 11
 )
 
 var (
@@ -27,6 +35,14 @@ func init() {
 	RegisterPropCreator(PropCreatedTime, func() Prop { return new(Uint64Prop) })
 	RegisterPropCreator(PropSubId, func() Prop { return new(Uint16Prop) })
 	RegisterPropCreator(PropTopic, func() Prop { return new(StringProp) })
+	RegisterPropCreator(PropClientId, func() Prop { return new(StringProp) })
+	RegisterPropCreator(PropSeq, func() Prop { return new(Uint64Prop) })
+	RegisterPropCreator(PropSinceSeq, func() Prop { return new(Uint64Prop) })
+	RegisterPropCreator(PropSinceTime, func() Prop { return new(Uint64Prop) })
+	RegisterPropCreator(PropErrMsg, func() Prop { return new(StringProp) })
+	RegisterPropCreator(PropStatus, func() Prop { return new(Uint16Prop) })
+	RegisterPropCreator(PropDeadline, func() Prop { return new(Uint64Prop) })
+	RegisterPropCreator(PropWindow, func() Prop { return new(Uint32Prop) })
 }
 
 // RegisterPropCreator registers a specified property creator based on the id.
@@ -0,0 +1,164 @@
+package pkts
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/happyxcj/gosocket/protocol"
+)
+
+// status codes, the codes less than 100 are reserved.
+const (
+	StatusOK         uint16 = 0
+	StatusUnknown    uint16 = 1
+	StatusBadRequest uint16 = 2
+	StatusNotFound   uint16 = 3
+	StatusInternal   uint16 = 4
+	// StatusDeadlineExceeded indicates the deadline of the request expired.
+	StatusDeadlineExceeded uint16 = 5
+	// StatusCanceled indicates the request was cancelled by the client.
+	StatusCanceled uint16 = 6
+	// StatusRateLimited indicates the request was rejected by the rate or concurrency limits.
+	StatusRateLimited uint16 = 7
+	// StatusUnavailable indicates the server is shutting down and doesn't accept
+	// new requests, the request can be retried on another connection.
+	StatusUnavailable uint16 = 8
+	// StatusInvalidArgument indicates the request message failed the validation.
+	StatusInvalidArgument uint16 = 9
+)
+
+var statusTexts = map[uint16]string{
+	StatusOK:               "ok",
+	StatusUnknown:          "unknown error",
+	StatusBadRequest:       "bad request",
+	StatusNotFound:         "not found",
+	StatusInternal:         "internal error",
+	StatusDeadlineExceeded: "deadline exceeded",
+	StatusCanceled:         "canceled",
+	StatusRateLimited:      "rate limited",
+	StatusUnavailable:      "unavailable",
+	StatusInvalidArgument:  "invalid argument",
+}
+
+// StatusText returns a text for the status code.
+// It returns the empty string if the code is unknown.
+func StatusText(code uint16) string {
+	return statusTexts[code]
+}
+
+// Status represents the result of a request, it's carried by a response packet
+// as follows:
+//
+// The code is carried by the property "PropStatus".
+// The message is carried by the property "PropErrMsg".
+// The details are carried by the application message.
+type Status struct {
+	Code    uint16
+	Message string
+	// Details is the optional application message encoded by the codec of the packet.
+	Details []byte
+}
+
+// NewStatus returns a Status with the code and message.
+func NewStatus(code uint16, message string) *Status {
+	return &Status{Code: code, Message: message}
+}
+
+// Errorf returns a Status with the code and formatted message.
+func Errorf(code uint16, format string, a ...interface{}) *Status {
+	return NewStatus(code, fmt.Sprintf(format, a...))
+}
+
+// ToStatus converts the err to a Status.
+// It returns the err itself if the err is a Status, a Status with the code
+// "StatusDeadlineExceeded" or "StatusCanceled" if the err is context.DeadlineExceeded
+// or context.Canceled, otherwise a Status with the code "StatusUnknown" and
+// the message of the err.
+func ToStatus(err error) *Status {
+	var st *Status
+	if errors.As(err, &st) {
+		return st
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return NewStatus(StatusDeadlineExceeded, err.Error())
+	}
+	if errors.Is(err, context.Canceled) {
+		return NewStatus(StatusCanceled, err.Error())
+	}
+	return NewStatus(StatusUnknown, err.Error())
+}
+
+func (s *Status) Error() string {
+	if s.Message == "" {
+		return fmt.Sprintf("status %v: %v", s.Code, StatusText(s.Code))
+	}
+	return fmt.Sprintf("status %v: %v", s.Code, s.Message)
+}
+
+// SetDetails encodes the v by the codec and sets the result as the details of the s.
+func (s *Status) SetDetails(codec byte, v interface{}) error {
+	bc, err := FindBodyCodec(codec)
+	if err != nil {
+		return err
+	}
+	s.Details, err = bc.Marshal(v)
+	return err
+}
+
+// DecodeDetails decodes the details by the codec,
+// and stores the result in the value pointed to by the v.
+func (s *Status) DecodeDetails(codec byte, v interface{}) error {
+	if len(s.Details) == 0 {
+		return nil
+	}
+	bc, err := FindBodyCodec(codec)
+	if err != nil {
+		return err
+	}
+	return bc.Unmarshal(s.Details, v)
+}
+
+// SetStatus sets the s to the p.
+func SetStatus(p DataPkt, s *Status) {
+	p.Props().WithUint16(PropStatus, s.Code)
+	if s.Message != "" {
+		p.Props().WithStr(PropErrMsg, s.Message)
+	}
+	p.SetBody(s.Details)
+}
+
+// HasStatus reports whether the p carries a status, including "StatusOK",
+// which means the p is a response.
+func HasStatus(p DataPkt) bool {
+	return p.Props().Has(PropStatus) || p.Props().Has(PropErrMsg)
+}
+
+// AsRequest returns the p as a ReqRespPkt if it's a request, which has no status.
+// The packets replied automatically must be checked by it, so that the peers
+// never reply to each other's error responses.
+func AsRequest(p protocol.Packet) (ReqRespPkt, bool) {
+	req, ok := p.(ReqRespPkt)
+	if !ok || HasStatus(req) {
+		return nil, false
+	}
+	return req, true
+}
+
+// GetStatus returns the error Status carried by the p if it exists.
+// A packet with the property "PropErrMsg" but without the property "PropStatus"
+// is treated as "StatusUnknown".
+func GetStatus(p DataPkt) (*Status, bool) {
+	code, hasCode := p.Props().GetUint16(PropStatus)
+	msg, hasMsg := p.Props().GetStr(PropErrMsg)
+	if !hasCode {
+		if !hasMsg {
+			return nil, false
+		}
+		code = StatusUnknown
+	}
+	if code == StatusOK {
+		return nil, false
+	}
+	return &Status{Code: code, Message: msg, Details: p.Body()}, true
+}
@@ -0,0 +1,213 @@
+package gosocket
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/happyxcj/gosocket/protocol"
+)
+
+// Presence is a registry of the online users, it maps the identity of
+// an authenticated user to all connections of the user.
+//
+// The closed connections must be removed by Unbind, which is done
+// automatically for the presence of a Server.
+type Presence struct {
+	mu sync.RWMutex
+	// users contains the connections of each online user.
+	users map[string]map[uint64]*QueueConn
+	// conns contains the bound user of each connection.
+	conns map[uint64]string
+
+	// events contains the events waiting to be dispatched.
+	events []presenceEvent
+
+	// dispatching indicates whether a goroutine is dispatching the events.
+	dispatching uint32
+
+	// onOnline is the callback when the first connection of a user is bound.
+	onOnline func(userId string, c *QueueConn)
+
+	// onOffline is the callback when the last connection of a user is unbound.
+	onOffline func(userId string, c *QueueConn)
+}
+
+// presenceEvent represents a user goes online or offline.
+type presenceEvent struct {
+	userId string
+	c      *QueueConn
+	online bool
+}
+
+type PresenceOpt func(*Presence)
+
+// OnOnline returns a PresenceOpt to set the callback when a user goes online.
+func OnOnline(onOnline func(string, *QueueConn)) PresenceOpt {
+	return func(p *Presence) {
+		p.onOnline = onOnline
+	}
+}
+
+// OnOffline returns a PresenceOpt to set the callback when a user goes offline.
+func OnOffline(onOffline func(string, *QueueConn)) PresenceOpt {
+	return func(p *Presence) {
+		p.onOffline = onOffline
+	}
+}
+
+func NewPresence(opts ...PresenceOpt) *Presence {
+	p := &Presence{
+		users: make(map[string]map[uint64]*QueueConn),
+		conns: make(map[uint64]string),
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	return p
+}
+
+// Bind binds the c to the user, it's usually called after the c is authenticated.
+// The c will be unbound from the previous user first if it has been bound.
+//
+// It returns an error "ErrConnClosed" if the c has been closed.
+func (p *Presence) Bind(userId string, c *QueueConn) error {
+	p.mu.Lock()
+	// Check it with the lock held, so the c is either rejected here
+	// or removed by the following Unbind.
+	if c.IsClosed() {
+		p.mu.Unlock()
+		return ErrConnClosed
+	}
+	if old, ok := p.conns[c.Id()]; ok {
+		if old == userId {
+			p.mu.Unlock()
+			return nil
+		}
+		p.remove(c)
+	}
+	conns, ok := p.users[userId]
+	if !ok {
+		conns = make(map[uint64]*QueueConn)
+		p.users[userId] = conns
+		p.events = append(p.events, presenceEvent{userId: userId, c: c, online: true})
+	}
+	conns[c.Id()] = c
+	p.conns[c.Id()] = userId
+	p.mu.Unlock()
+	p.dispatch()
+	return nil
+}
+
+// Unbind unbinds the c from its user.
+func (p *Presence) Unbind(c *QueueConn) {
+	p.mu.Lock()
+	p.remove(c)
+	p.mu.Unlock()
+	p.dispatch()
+}
+
+// remove removes the c with the lock held,
+// and queues an offline event if it's the last connection of the user.
+func (p *Presence) remove(c *QueueConn) {
+	userId, ok := p.conns[c.Id()]
+	if !ok {
+		return
+	}
+	delete(p.conns, c.Id())
+	conns := p.users[userId]
+	delete(conns, c.Id())
+	if len(conns) == 0 {
+		delete(p.users, userId)
+		p.events = append(p.events, presenceEvent{userId: userId, c: c, online: false})
+	}
+}
+
+// dispatch calls the callbacks for the queued events in order.
+// Only one goroutine dispatches the events at a time, the events queued by
+// the others during dispatching are handled by the dispatching goroutine.
+func (p *Presence) dispatch() {
+	for atomic.CompareAndSwapUint32(&p.dispatching, 0, 1) {
+		for {
+			p.mu.Lock()
+			events := p.events
+			p.events = nil
+			p.mu.Unlock()
+			if len(events) == 0 {
+				break
+			}
+			for _, e := range events {
+				if e.online && p.onOnline != nil {
+					p.onOnline(e.userId, e.c)
+				} else if !e.online && p.onOffline != nil {
+					p.onOffline(e.userId, e.c)
+				}
+			}
+		}
+		atomic.StoreUint32(&p.dispatching, 0)
+		// Check again for the events queued before the flag is reset.
+		p.mu.RLock()
+		n := len(p.events)
+		p.mu.RUnlock()
+		if n == 0 {
+			return
+		}
+	}
+}
+
+// User returns the user bound to the c if it exists.
+func (p *Presence) User(c *QueueConn) (string, bool) {
+	p.mu.RLock()
+	userId, ok := p.conns[c.Id()]
+	p.mu.RUnlock()
+	return userId, ok
+}
+
+// IsOnline returns a bool indicating whether the user has any connection.
+func (p *Presence) IsOnline(userId string) bool {
+	p.mu.RLock()
+	_, ok := p.users[userId]
+	p.mu.RUnlock()
+	return ok
+}
+
+// UserConns returns all connections of the user.
+func (p *Presence) UserConns(userId string) []*QueueConn {
+	p.mu.RLock()
+	conns := make([]*QueueConn, 0, len(p.users[userId]))
+	for _, c := range p.users[userId] {
+		conns = append(conns, c)
+	}
+	p.mu.RUnlock()
+	return conns
+}
+
+// OnlineUsers returns the ids of all online users.
+func (p *Presence) OnlineUsers() []string {
+	p.mu.RLock()
+	users := make([]string, 0, len(p.users))
+	for userId := range p.users {
+		users = append(users, userId)
+	}
+	p.mu.RUnlock()
+	return users
+}
+
+// UserNum returns the number of the online users.
+func (p *Presence) UserNum() int {
+	p.mu.RLock()
+	n := len(p.users)
+	p.mu.RUnlock()
+	return n
+}
+
+// SendToUser sends the pkt to all connections of the user.
+// It returns the number of connections the pkt is sent to successfully.
+func (p *Presence) SendToUser(userId string, pkt protocol.Packet) int {
+	n := 0
+	for _, c := range p.UserConns(userId) {
+		if c.Send(pkt) == nil {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,545 @@
+package pubsub
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/happyxcj/gosocket"
+	"github.com/happyxcj/gosocket/pkts"
+	"github.com/happyxcj/gosocket/protocol"
+)
+
+const (
+	defaultReplayTimeout = 10 * time.Second
+	defaultSessionExpiry = 24 * time.Hour
+	// sessionSweepInterval is the max interval to remove the expired sessions.
+	sessionSweepInterval = time.Minute
+)
+
+// Broker dispatches the published packets to the subscribers of the topics.
+//
+// A subscription with a client id is persistent: the packets published while
+// the client is offline are queued in the store, and they will be replayed in order
+// when the client subscribes to the same topic again. The persistent subscriptions
+// of a client offline longer than the session expiry are removed with their
+// queued packets, see SessionExpiry.
+//
+// Every published packet is assigned a sequence number of its topic by the property
+// "PropSeq", the sequence number of a topic is kept while the topic has subscribers
+// or history, otherwise it restarts from 1. The latest packets of each topic are kept in the history, so that
+// a subscription can request to replay the packets since a sequence number or a time.
+type Broker struct {
+	mu sync.Mutex
+
+	store Store
+
+	// historySize is the maximum number of packets kept in the history of each topic.
+	// A zero value of it means disable the history.
+	historySize int
+
+	// replayTimeout is the max duration to replay the packets to a subscriber.
+	replayTimeout time.Duration
+
+	// sessionExpiry is the duration to keep the persistent subscriptions of an offline client.
+	// A non-positive value of it means never expire.
+	sessionExpiry time.Duration
+
+	// lastSweep is the last time to remove the expired sessions.
+	lastSweep time.Time
+
+	// seqs contains the latest sequence number of each topic.
+	seqs map[string]uint64
+
+	// histories contains the history of each topic.
+	histories map[string]*history
+
+	// topics contains the online subscribers of each topic.
+	topics map[string]map[gosocket.Conn]struct{}
+
+	// conns contains the subscribed topics of each online subscriber.
+	conns map[gosocket.Conn]*subscriber
+
+	// clients contains the persistent subscriptions keyed by the client id.
+	clients map[string]*subscriber
+
+	// replaying contains the subscriptions being replayed.
+	replaying map[replayKey]struct{}
+}
+
+type replayKey struct {
+	conn  gosocket.Conn
+	topic string
+}
+
+// subscriber represents the subscriptions of a connection or a client.
+type subscriber struct {
+	// clientId is the client id of the persistent subscriptions,
+	// it's empty if the subscriptions are not persistent.
+	clientId string
+	// conn is the current connection of the subscriber,
+	// it's nil if the persistent subscriber is offline.
+	conn   gosocket.Conn
+	topics map[string]struct{}
+	// offlineAt is the time the persistent subscriber goes offline.
+	offlineAt time.Time
+}
+
+func newSubscriber(clientId string) *subscriber {
+	return &subscriber{clientId: clientId, topics: make(map[string]struct{})}
+}
+
+type BrokerOpt func(*Broker)
+
+// MsgStore returns a BrokerOpt to set the store for the persistent subscriptions.
+func MsgStore(store Store) BrokerOpt {
+	return func(b *Broker) {
+		b.store = store
+	}
+}
+
+// HistorySize returns a BrokerOpt to set the maximum number of packets kept
+// in the history of each topic.
+func HistorySize(size int) BrokerOpt {
+	return func(b *Broker) {
+		b.historySize = size
+	}
+}
+
+// ReplayTimeout returns a BrokerOpt to set the max duration to replay the packets
+// to a subscriber, the replaying waits for the space of the sending queue of
+// the connection if the connection supports it, such as gosocket.QueueConn.
+func ReplayTimeout(t time.Duration) BrokerOpt {
+	return func(b *Broker) {
+		b.replayTimeout = t
+	}
+}
+
+// SessionExpiry returns a BrokerOpt to set the duration to keep the persistent
+// subscriptions of an offline client, a non-positive d means never expire.
+// It's 24 hours by default.
+func SessionExpiry(d time.Duration) BrokerOpt {
+	return func(b *Broker) {
+		b.sessionExpiry = d
+	}
+}
+
+func NewBroker(opts ...BrokerOpt) *Broker {
+	b := &Broker{
+		seqs:      make(map[string]uint64),
+		histories: make(map[string]*history),
+		topics:    make(map[string]map[gosocket.Conn]struct{}),
+		conns:     make(map[gosocket.Conn]*subscriber),
+		clients:   make(map[string]*subscriber),
+		replaying: make(map[replayKey]struct{}),
+	}
+	b.sessionExpiry = defaultSessionExpiry
+	for _, opt := range opts {
+		opt(b)
+	}
+	if b.store == nil {
+		b.store = NewMemStore()
+	}
+	if b.replayTimeout <= 0 {
+		b.replayTimeout = defaultReplayTimeout
+	}
+	return b
+}
+
+// SubOpts contains the options of a subscription.
+type SubOpts struct {
+	clientId string
+	// sinceSeq replays the packets whose sequence number is greater than it.
+	sinceSeq uint64
+	// sinceTime replays the packets published no earlier than it.
+	sinceTime time.Time
+	// replay indicates whether to replay the packets in the history.
+	replay bool
+}
+
+// SubOpt specifies an option for a subscription.
+type SubOpt func(*SubOpts)
+
+// ClientId returns a SubOpt to make the subscription persistent for the client.
+// It has no effect if the id is empty.
+func ClientId(id string) SubOpt {
+	return func(o *SubOpts) {
+		o.clientId = id
+	}
+}
+
+// SinceSeq returns a SubOpt to replay the packets in the history whose
+// sequence number is greater than the seq before any packet published later.
+func SinceSeq(seq uint64) SubOpt {
+	return func(o *SubOpts) {
+		o.sinceSeq = seq
+		o.replay = true
+	}
+}
+
+// SinceTime returns a SubOpt to replay the packets in the history published
+// no earlier than the t before any packet published later.
+func SinceTime(t time.Time) SubOpt {
+	return func(o *SubOpts) {
+		o.sinceTime = t
+		o.replay = true
+	}
+}
+
+// PktSubOpts returns the options carried by the properties of the subscribing packet,
+// the property "PropSinceTime" is the time in Unix seconds.
+func PktSubOpts(p pkts.DataPkt) []SubOpt {
+	var opts []SubOpt
+	if id, ok := p.Props().GetStr(pkts.PropClientId); ok {
+		opts = append(opts, ClientId(id))
+	}
+	if seq, ok := p.Props().GetUint64(pkts.PropSinceSeq); ok {
+		opts = append(opts, SinceSeq(seq))
+	}
+	if sec, ok := p.Props().GetInt64(pkts.PropSinceTime); ok {
+		opts = append(opts, SinceTime(time.Unix(sec, 0)))
+	}
+	return opts
+}
+
+// Subscribe subscribes the conn to the topic.
+//
+// For a persistent subscription, all queued packets on the topic will be sent
+// to the conn before any packet published later, followed by the requested
+// packets in the history that have not been queued.
+//
+// The packets are replayed without holding the lock of the b, and the conn
+// receives the packets published later only after the replaying succeeds.
+func (b *Broker) Subscribe(conn gosocket.Conn, topic string, opts ...SubOpt) error {
+	o := &SubOpts{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	b.mu.Lock()
+	s, ok := b.conns[conn]
+	if !ok {
+		s = b.attach(conn, o.clientId)
+	}
+	s.topics[topic] = struct{}{}
+	if s.clientId == "" && !o.replay {
+		b.addTopicSub(conn, topic)
+		b.mu.Unlock()
+		return nil
+	}
+	key := replayKey{conn: conn, topic: topic}
+	if _, ok := b.replaying[key]; ok {
+		// The conn will be added by the subscribing being replayed.
+		b.mu.Unlock()
+		return nil
+	}
+	b.replaying[key] = struct{}{}
+	b.mu.Unlock()
+	err := b.replay(s, key, o)
+	if err != nil {
+		b.mu.Lock()
+		delete(b.replaying, key)
+		b.mu.Unlock()
+	}
+	return err
+}
+
+// replay sends the queued packets and the requested packets in the history of
+// the topic to the conn, then adds the conn to the subscribers of the topic.
+//
+// The packets published while sending are queued for the persistent subscriber
+// or kept in the history, so they are sent in the next round until there is
+// nothing left, and the conn is added in the same critical section as the check.
+func (b *Broker) replay(s *subscriber, key replayKey, o *SubOpts) error {
+	lastSeq := o.sinceSeq
+	for {
+		b.mu.Lock()
+		_, subscribed := s.topics[key.topic]
+		if b.conns[key.conn] != s || !subscribed {
+			// The conn has unsubscribed or disconnected meanwhile.
+			delete(b.replaying, key)
+			b.mu.Unlock()
+			return nil
+		}
+		var ps []*pkts.PubPkt
+		if s.clientId != "" {
+			queued, err := b.store.Drain(s.clientId, key.topic)
+			if err != nil {
+				b.mu.Unlock()
+				return err
+			}
+			ps = queued
+		}
+		queuedLen := len(ps)
+		if o.replay {
+			after := lastSeq
+			if queuedLen > 0 {
+				if seq, _ := ps[queuedLen-1].Props().GetUint64(pkts.PropSeq); seq > after {
+					after = seq
+				}
+			}
+			ps = append(ps, b.history(key.topic, after, o.sinceTime)...)
+		}
+		if len(ps) == 0 {
+			delete(b.replaying, key)
+			b.addTopicSub(key.conn, key.topic)
+			b.mu.Unlock()
+			return nil
+		}
+		b.mu.Unlock()
+		n, err := b.send(key.conn, ps)
+		if n > 0 {
+			lastSeq, _ = ps[n-1].Props().GetUint64(pkts.PropSeq)
+		}
+		if err != nil {
+			if n < queuedLen {
+				b.requeue(s.clientId, key.topic, ps[n:queuedLen])
+			}
+			return err
+		}
+	}
+}
+
+// requeue queues the unsent packets again before the packets queued while sending,
+// they will be replayed by the next subscribing.
+func (b *Broker) requeue(clientId, topic string, unsent []*pkts.PubPkt) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	queued, _ := b.store.Drain(clientId, topic)
+	for _, p := range append(unsent, queued...) {
+		b.store.Append(clientId, p)
+	}
+}
+
+// history returns the packets in the history of the topic whose sequence number
+// is greater than the seq and published no earlier than the t.
+func (b *Broker) history(topic string, seq uint64, t time.Time) []*pkts.PubPkt {
+	h, ok := b.histories[topic]
+	if !ok {
+		return nil
+	}
+	var ps []*pkts.PubPkt
+	h.forEach(func(e *entry) {
+		if e.seq > seq && !e.time.Before(t) {
+			ps = append(ps, e.pkt)
+		}
+	})
+	return ps
+}
+
+// contextSender is implemented by the connections that can wait for the space
+// of their sending queue, such as gosocket.QueueConn.
+type contextSender interface {
+	SendContext(ctx context.Context, p protocol.Packet) error
+}
+
+// send sends the ps to the conn in order and returns the number of the sent packets.
+// It waits for the space of the sending queue of the conn if possible, so that
+// replaying a large number of packets doesn't overflow the queue.
+func (b *Broker) send(conn gosocket.Conn, ps []*pkts.PubPkt) (int, error) {
+	cs, ok := conn.(contextSender)
+	if !ok {
+		for i, p := range ps {
+			if err := conn.Send(p); err != nil {
+				return i, err
+			}
+		}
+		return len(ps), nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), b.replayTimeout)
+	defer cancel()
+	for i, p := range ps {
+		if err := cs.SendContext(ctx, p); err != nil {
+			return i, err
+		}
+	}
+	return len(ps), nil
+}
+
+// attach returns the subscriber for the conn, the persistent subscriber
+// identified by the clientId will be taken over by the conn.
+func (b *Broker) attach(conn gosocket.Conn, clientId string) *subscriber {
+	if clientId == "" {
+		s := newSubscriber("")
+		s.conn = conn
+		b.conns[conn] = s
+		return s
+	}
+	s, ok := b.clients[clientId]
+	if !ok {
+		s = newSubscriber(clientId)
+		b.clients[clientId] = s
+	}
+	if s.conn != nil {
+		// The client has reconnected before the old connection is disconnected.
+		b.detach(s.conn)
+	}
+	s.conn = conn
+	b.conns[conn] = s
+	return s
+}
+
+// Unsubscribe unsubscribes the conn from the topic.
+// The queued packets on the topic will be discarded for a persistent subscription.
+func (b *Broker) Unsubscribe(conn gosocket.Conn, topic string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	s, ok := b.conns[conn]
+	if !ok {
+		return nil
+	}
+	b.removeTopicSub(conn, topic)
+	delete(s.topics, topic)
+	b.prune(topic)
+	if s.clientId == "" {
+		if len(s.topics) == 0 {
+			delete(b.conns, conn)
+		}
+		return nil
+	}
+	if len(s.topics) == 0 {
+		delete(b.clients, s.clientId)
+		delete(b.conns, conn)
+	}
+	_, err := b.store.Drain(s.clientId, topic)
+	return err
+}
+
+// Disconnect removes all online subscriptions of the conn, it's usually called
+// when the conn is closed.
+// The persistent subscriptions of the conn will be kept until the client subscribes again.
+func (b *Broker) Disconnect(conn gosocket.Conn) {
+	b.mu.Lock()
+	b.detach(conn)
+	b.mu.Unlock()
+}
+
+func (b *Broker) detach(conn gosocket.Conn) {
+	s, ok := b.conns[conn]
+	if !ok {
+		return
+	}
+	for topic := range s.topics {
+		b.removeTopicSub(conn, topic)
+		if s.clientId == "" {
+			b.prune(topic)
+		}
+	}
+	delete(b.conns, conn)
+	s.conn, s.offlineAt = nil, time.Now()
+}
+
+// expire removes the persistent subscriptions and the queued packets of the clients
+// offline longer than the session expiry.
+func (b *Broker) expire(now time.Time) {
+	elapsed := now.Sub(b.lastSweep)
+	if b.sessionExpiry <= 0 || elapsed < sessionSweepInterval && elapsed < b.sessionExpiry {
+		return
+	}
+	b.lastSweep = now
+	for id, s := range b.clients {
+		if s.conn != nil || now.Sub(s.offlineAt) <= b.sessionExpiry {
+			continue
+		}
+		delete(b.clients, id)
+		for topic := range s.topics {
+			b.store.Drain(id, topic)
+			b.prune(topic)
+		}
+	}
+}
+
+func (b *Broker) addTopicSub(conn gosocket.Conn, topic string) {
+	subs, ok := b.topics[topic]
+	if !ok {
+		subs = make(map[gosocket.Conn]struct{})
+		b.topics[topic] = subs
+	}
+	subs[conn] = struct{}{}
+}
+
+func (b *Broker) removeTopicSub(conn gosocket.Conn, topic string) {
+	subs := b.topics[topic]
+	delete(subs, conn)
+	if len(subs) == 0 {
+		delete(b.topics, topic)
+	}
+}
+
+// Publish sends the p to all online subscribers of the p's topic, and queues
+// the p for the offline persistent subscribers.
+//
+// A copy of the p is assigned the next sequence number of the topic and sent,
+// the p itself is not modified, but the copy shares the body of it,
+// so the body must not be modified after publishing.
+//
+// It returns the first error of the store, the failures of sending to
+// the online subscribers are ignored.
+func (b *Broker) Publish(p *pkts.PubPkt) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.expire(time.Now())
+	p = b.record(p)
+	defer b.prune(p.Topic())
+	var err error
+	for conn := range b.topics[p.Topic()] {
+		if conn.Send(p) == nil {
+			continue
+		}
+		// The packet can't be delivered to a persistent subscriber, queue it.
+		if s := b.conns[conn]; s.clientId != "" {
+			if e := b.store.Append(s.clientId, p); err == nil {
+				err = e
+			}
+		}
+	}
+	subs := b.topics[p.Topic()]
+	for id, s := range b.clients {
+		if _, ok := s.topics[p.Topic()]; !ok {
+			continue
+		}
+		if _, ok := subs[s.conn]; ok && s.conn != nil {
+			// The client has subscribed to the topic again.
+			continue
+		}
+		if e := b.store.Append(id, p); err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// record returns a copy of the p with the next sequence number of the topic,
+// and adds the copy to the history of the topic.
+func (b *Broker) record(p *pkts.PubPkt) *pkts.PubPkt {
+	seq := b.seqs[p.Topic()] + 1
+	b.seqs[p.Topic()] = seq
+	p = p.Clone()
+	p.Props().WithUint64(pkts.PropSeq, seq)
+	if b.historySize <= 0 {
+		return p
+	}
+	h, ok := b.histories[p.Topic()]
+	if !ok {
+		h = newHistory(b.historySize)
+		b.histories[p.Topic()] = h
+	}
+	h.add(entry{seq: seq, time: time.Now(), pkt: p})
+	return p
+}
+
+// prune removes the sequence number of the topic if the topic has
+// neither subscribers nor history.
+func (b *Broker) prune(topic string) {
+	if _, ok := b.topics[topic]; ok {
+		return
+	}
+	if _, ok := b.histories[topic]; ok {
+		return
+	}
+	for _, s := range b.clients {
+		if _, ok := s.topics[topic]; ok {
+			return
+		}
+	}
+	delete(b.seqs, topic)
+}
@@ -0,0 +1,138 @@
+package pubsub
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/happyxcj/gosocket/pkts"
+	"github.com/happyxcj/gosocket/protocol"
+)
+
+// testConn records the bodies of the sent packets, SendContext waits for
+// the release before sending if it's not nil.
+type testConn struct {
+	mu      sync.Mutex
+	got     []string
+	release chan struct{}
+}
+
+func (c *testConn) Send(p protocol.Packet) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.got = append(c.got, string(p.Body()))
+	return nil
+}
+
+func (c *testConn) SendContext(ctx context.Context, p protocol.Packet) error {
+	if c.release != nil {
+		select {
+		case <-c.release:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return c.Send(p)
+}
+
+func (c *testConn) Receive() (protocol.Packet, error) { select {} }
+func (c *testConn) Close() error                      { return nil }
+
+func (c *testConn) bodies() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]string(nil), c.got...)
+}
+
+func publish(b *Broker, topic string, bodies ...string) {
+	for _, body := range bodies {
+		b.Publish(pkts.NewEasyPubPkt(topic, 1, []byte(body)))
+	}
+}
+
+func TestSubscribeReplayUnlocked(t *testing.T) {
+	b := NewBroker()
+	c1 := &testConn{}
+	b.Subscribe(c1, "t", ClientId("a"))
+	b.Disconnect(c1)
+	publish(b, "t", "1", "2")
+
+	c2 := &testConn{release: make(chan struct{})}
+	errCh := make(chan error, 1)
+	go func() { errCh <- b.Subscribe(c2, "t", ClientId("a")) }()
+	time.Sleep(10 * time.Millisecond)
+
+	// The publishing must not wait for the replaying.
+	done := make(chan struct{})
+	go func() {
+		publish(b, "t", "3")
+		publish(b, "u", "x")
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("the publishing is blocked by the replaying")
+	}
+	close(c2.release)
+	if err := <-errCh; err != nil {
+		t.Fatal(err)
+	}
+	publish(b, "t", "4")
+	want := []string{"1", "2", "3", "4"}
+	if got := c2.bodies(); len(got) != len(want) || got[0] != "1" || got[2] != "3" || got[3] != "4" {
+		t.Fatalf("expect %v, got %v", want, got)
+	}
+}
+
+func TestSubscribeReplayTimeout(t *testing.T) {
+	b := NewBroker(ReplayTimeout(20 * time.Millisecond))
+	c1 := &testConn{}
+	b.Subscribe(c1, "t", ClientId("a"))
+	b.Disconnect(c1)
+	publish(b, "t", "1", "2")
+
+	c2 := &testConn{release: make(chan struct{})}
+	if err := b.Subscribe(c2, "t", ClientId("a")); err != context.DeadlineExceeded {
+		t.Fatalf("expect %v, got %v", context.DeadlineExceeded, err)
+	}
+	b.Disconnect(c2)
+	c3 := &testConn{}
+	if err := b.Subscribe(c3, "t", ClientId("a")); err != nil {
+		t.Fatal(err)
+	}
+	if got := c3.bodies(); len(got) != 2 || got[0] != "1" || got[1] != "2" {
+		t.Fatalf("the unsent packets are not queued again: %v", got)
+	}
+}
+
+func TestSubscribeHistory(t *testing.T) {
+	b := NewBroker(HistorySize(3))
+	publish(b, "t", "1", "2", "3", "4")
+	c := &testConn{}
+	if err := b.Subscribe(c, "t", SinceSeq(2)); err != nil {
+		t.Fatal(err)
+	}
+	publish(b, "t", "5")
+	if got := c.bodies(); len(got) != 3 || got[0] != "3" || got[2] != "5" {
+		t.Fatalf("expect [3 4 5], got %v", got)
+	}
+}
+
+func TestSessionExpiry(t *testing.T) {
+	store := NewMemStore()
+	b := NewBroker(MsgStore(store), SessionExpiry(10*time.Millisecond))
+	c := &testConn{}
+	b.Subscribe(c, "t", ClientId("a"))
+	b.Disconnect(c)
+	publish(b, "t", "1")
+	time.Sleep(20 * time.Millisecond)
+	publish(b, "u", "x")
+	if _, ok := b.clients["a"]; ok {
+		t.Fatal("the expired session is kept")
+	}
+	if store.len() != 0 {
+		t.Fatalf("the queued packets of the expired session are kept: %d", store.len())
+	}
+}
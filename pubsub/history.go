@@ -0,0 +1,44 @@
+package pubsub
+
+import (
+	"time"
+
+	"github.com/happyxcj/gosocket/pkts"
+)
+
+// entry is a published packet recorded in the history.
+type entry struct {
+	seq  uint64
+	time time.Time
+	pkt  *pkts.PubPkt
+}
+
+// history is a bounded ring buffer of the latest published packets on a topic.
+type history struct {
+	entries []entry
+	// start is the index of the oldest entry.
+	start int
+	size  int
+}
+
+func newHistory(capacity int) *history {
+	return &history{entries: make([]entry, capacity)}
+}
+
+// add records the e, the oldest entry will be overwritten if the history is full.
+func (h *history) add(e entry) {
+	if h.size < len(h.entries) {
+		h.entries[(h.start+h.size)%len(h.entries)] = e
+		h.size++
+		return
+	}
+	h.entries[h.start] = e
+	h.start = (h.start + 1) % len(h.entries)
+}
+
+// forEach calls the f for each entry from the oldest to the latest.
+func (h *history) forEach(f func(e *entry)) {
+	for i := 0; i < h.size; i++ {
+		f(&h.entries[(h.start+i)%len(h.entries)])
+	}
+}
@@ -0,0 +1,34 @@
+package pubsub
+
+import (
+	"github.com/happyxcj/gosocket/pkts"
+)
+
+// Store describes how to queue the published packets for the persistent
+// subscriptions while the subscribers are offline.
+//
+// The implementations must be safe for concurrent use.
+type Store interface {
+	// Append queues the p for the client identified by the clientId.
+	Append(clientId string, p *pkts.PubPkt) error
+
+	// Drain removes and returns all queued packets on the topic for the client
+	// identified by the clientId, the packets are returned in the order they were appended.
+	Drain(clientId string, topic string) ([]*pkts.PubPkt, error)
+
+	// Close releases the resources of the store.
+	Close() error
+}
+
+// takeTopic splits the queue into the packets on the topic and the remaining ones,
+// both of them keep the original order.
+func takeTopic(queue []*pkts.PubPkt, topic string) (taken, remaining []*pkts.PubPkt) {
+	for _, p := range queue {
+		if p.Topic() == topic {
+			taken = append(taken, p)
+		} else {
+			remaining = append(remaining, p)
+		}
+	}
+	return taken, remaining
+}
@@ -0,0 +1,255 @@
+package pubsub
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/happyxcj/gosocket/pkts"
+	"github.com/happyxcj/gosocket/protocol"
+)
+
+// record operations of the file store.
+const (
+	opAppend byte = iota + 1
+	opDrain
+)
+
+// minCompactRecords is the min number of the records in the file to compact it.
+const minCompactRecords = 1024
+
+var _ Store = (*FileStore)(nil)
+
+// FileStore is a Store that records every operation to an append-only file,
+// so the queued packets survive the restart of the process.
+//
+// The record format is as follows:
+//
+//	1Byte(operation)+2Bytes(client id size)+xBytes(client id)+
+//	xBytes(encoded packet) for the appending operation or
+//	2Bytes(topic size)+xBytes(topic) for the draining operation.
+//
+// All queued packets are also kept in memory to serve the draining operations.
+// The file is compacted to contain only the queued packets when it's opened, and
+// when the records are more than twice the queued packets, see compact.
+type FileStore struct {
+	mu   sync.Mutex
+	name string
+	f    *os.File
+	// records is the number of the records in the file.
+	records int
+	// buf buffers a whole record, so that the record is written to the file by a single call.
+	buf   bytes.Buffer
+	w     *protocol.Writer
+	codec *protocol.Codec
+	mem   *MemStore
+}
+
+// OpenFileStore opens the named file and restores the queued packets from it,
+// the file will be created if it does not exist. The opts are applied to the queues
+// in memory, the packets dropped or expired by them are removed from the file
+// by the compaction.
+//
+// An incomplete record at the end of the file, which is usually caused by a crash,
+// will be discarded.
+func OpenFileStore(name string, opts ...MemStoreOpt) (*FileStore, error) {
+	f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	s := &FileStore{
+		name: name,
+		f:    f,
+		mem:  NewMemStore(opts...),
+	}
+	s.w = protocol.NewWriter(&s.buf, protocol.BigEndian)
+	s.codec = protocol.NewCodec(protocol.NewWriter(&s.buf, protocol.BigEndian), nil)
+	if err = s.load(); err == nil && s.records > s.mem.len() {
+		err = s.compact()
+	}
+	if err != nil {
+		s.f.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *FileStore) Append(clientId string, p *pkts.PubPkt) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.encodeAppend(clientId, p); err != nil {
+		return err
+	}
+	if err := s.write(); err != nil {
+		return err
+	}
+	return s.mem.Append(clientId, p)
+}
+
+// encodeAppend encodes the appending record of the p into the buf.
+func (s *FileStore) encodeAppend(clientId string, p *pkts.PubPkt) error {
+	s.buf.Reset()
+	s.w.ResetBuf(make([]byte, 3+len(clientId)))
+	s.w.PutByte(opAppend)
+	s.w.PutUint16(uint16(len(clientId)))
+	s.w.PutString(clientId)
+	s.w.Flush()
+	return s.codec.Write(p)
+}
+
+// write writes the record in the buf to the file.
+func (s *FileStore) write() error {
+	if _, err := s.f.Write(s.buf.Bytes()); err != nil {
+		return err
+	}
+	s.records++
+	return nil
+}
+
+func (s *FileStore) Drain(clientId string, topic string) ([]*pkts.PubPkt, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.buf.Reset()
+	s.w.ResetBuf(make([]byte, 5+len(clientId)+len(topic)))
+	s.w.PutByte(opDrain)
+	s.w.PutUint16(uint16(len(clientId)))
+	s.w.PutString(clientId)
+	s.w.PutUint16(uint16(len(topic)))
+	s.w.PutString(topic)
+	s.w.Flush()
+	if err := s.write(); err != nil {
+		return nil, err
+	}
+	ps, err := s.mem.Drain(clientId, topic)
+	if s.records >= minCompactRecords && s.records > 2*s.mem.len() {
+		// The file is still valid if the compaction fails, it's tried again later.
+		s.compact()
+	}
+	return ps, err
+}
+
+// compact rewrites the file with the appending records of the queued packets only.
+// The new file is written to a temporary file first and then renamed to the file,
+// so the file is always valid, and the new file is used by the later records.
+func (s *FileStore) compact() error {
+	tmp := s.name + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	records := 0
+	s.mem.forEach(func(clientId string, p *pkts.PubPkt) {
+		if err == nil {
+			if err = s.encodeAppend(clientId, p); err == nil {
+				_, err = w.Write(s.buf.Bytes())
+				records++
+			}
+		}
+	})
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if err == nil {
+		err = os.Rename(tmp, s.name)
+	}
+	if err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	s.f.Close()
+	s.f, s.records = f, records
+	return nil
+}
+
+func (s *FileStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.f.Close()
+}
+
+// load replays all records in the file to restore the queued packets.
+func (s *FileStore) load() error {
+	cr := &countingReader{r: bufio.NewReader(s.f)}
+	r := protocol.NewReader(cr, protocol.BigEndian)
+	codec := protocol.NewCodec(nil, protocol.NewReader(cr, protocol.BigEndian))
+	var head [3]byte
+	for {
+		// offset is the end of the last complete record.
+		offset := cr.n
+		err := s.loadRecord(r, codec, head[:])
+		if err == nil {
+			s.records++
+			continue
+		}
+		if err == io.EOF && cr.n == offset {
+			return nil
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			// Discard the incomplete record.
+			return s.f.Truncate(offset)
+		}
+		return err
+	}
+}
+
+func (s *FileStore) loadRecord(r *protocol.Reader, codec *protocol.Codec, head []byte) error {
+	if _, err := r.ReadFull(head); err != nil {
+		return err
+	}
+	op := r.Byte()
+	clientId, err := readString(r, int(r.Uint16()))
+	if err != nil {
+		return err
+	}
+	switch op {
+	case opAppend:
+		p, err := codec.Read()
+		if err != nil {
+			return err
+		}
+		pkt, ok := p.(*pkts.PubPkt)
+		if !ok {
+			return protocol.ErrDecodeBadPacket
+		}
+		return s.mem.Append(clientId, pkt)
+	case opDrain:
+		if _, err = r.ReadFull(head[:2]); err != nil {
+			return err
+		}
+		topic, err := readString(r, int(r.Uint16()))
+		if err != nil {
+			return err
+		}
+		_, err = s.mem.Drain(clientId, topic)
+		return err
+	default:
+		return protocol.ErrDecodeBadPacket
+	}
+}
+
+// readString reads a string with the given size from the underlying io.Reader of the r.
+func readString(r *protocol.Reader, size int) (string, error) {
+	if _, err := r.ReadFull(make([]byte, size)); err != nil {
+		return "", err
+	}
+	return r.String(size), nil
+}
+
+// countingReader counts the number of bytes read from the underlying io.Reader.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.n += int64(n)
+	return n, err
+}
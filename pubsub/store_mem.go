@@ -0,0 +1,145 @@
+package pubsub
+
+import (
+	"sync"
+	"time"
+
+	"github.com/happyxcj/gosocket/pkts"
+)
+
+const (
+	defaultMaxQueueSize = 1024
+	defaultQueueExpiry  = 24 * time.Hour
+	// queueSweepInterval is the max interval to remove the expired queues.
+	queueSweepInterval = time.Minute
+)
+
+var _ Store = (*MemStore)(nil)
+
+// MemStore is a Store that keeps all queued packets in memory.
+type MemStore struct {
+	mu     sync.Mutex
+	queues map[string]*queue
+	// size is the total number of the queued packets.
+	size int
+	// maxSize is the maximum number of queued packets for each client,
+	// the oldest packet will be dropped if the queue is full.
+	// It's default value is 1024, a non-positive value of it means no limit.
+	maxSize int
+	// expiry is the duration to keep a queue neither appended nor drained,
+	// so the queues of the clients never coming back are released.
+	// It's default value is "24*time.Hour", a non-positive value of it means never expire.
+	expiry time.Duration
+	// lastSweep is the last time to remove the expired queues.
+	lastSweep time.Time
+}
+
+// queue contains the queued packets of a client.
+type queue struct {
+	ps []*pkts.PubPkt
+	// updated is the last time the queue is appended or drained.
+	updated time.Time
+}
+
+type MemStoreOpt func(*MemStore)
+
+// MaxQueueSize returns a MemStoreOpt to set the maximum number of queued packets for each client.
+func MaxQueueSize(size int) MemStoreOpt {
+	return func(s *MemStore) {
+		s.maxSize = size
+	}
+}
+
+// QueueExpiry returns a MemStoreOpt to set the duration to keep a queue
+// neither appended nor drained.
+func QueueExpiry(d time.Duration) MemStoreOpt {
+	return func(s *MemStore) {
+		s.expiry = d
+	}
+}
+
+func NewMemStore(opts ...MemStoreOpt) *MemStore {
+	s := &MemStore{
+		queues:  make(map[string]*queue),
+		maxSize: defaultMaxQueueSize,
+		expiry:  defaultQueueExpiry,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+func (s *MemStore) Append(clientId string, p *pkts.PubPkt) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	s.sweep(now)
+	q, ok := s.queues[clientId]
+	if !ok {
+		q = &queue{}
+		s.queues[clientId] = q
+	}
+	q.ps = append(q.ps, p)
+	s.size++
+	if s.maxSize > 0 && len(q.ps) > s.maxSize {
+		s.size -= len(q.ps) - s.maxSize
+		q.ps = q.ps[len(q.ps)-s.maxSize:]
+	}
+	q.updated = now
+	return nil
+}
+
+func (s *MemStore) Drain(clientId string, topic string) ([]*pkts.PubPkt, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	q, ok := s.queues[clientId]
+	if !ok {
+		return nil, nil
+	}
+	taken, remaining := takeTopic(q.ps, topic)
+	s.size -= len(taken)
+	if len(remaining) == 0 {
+		delete(s.queues, clientId)
+	} else {
+		q.ps, q.updated = remaining, time.Now()
+	}
+	return taken, nil
+}
+
+func (s *MemStore) Close() error {
+	return nil
+}
+
+// sweep removes the expired queues with the lock held.
+func (s *MemStore) sweep(now time.Time) {
+	if s.expiry <= 0 || now.Sub(s.lastSweep) < queueSweepInterval && now.Sub(s.lastSweep) < s.expiry {
+		return
+	}
+	s.lastSweep = now
+	for clientId, q := range s.queues {
+		if now.Sub(q.updated) > s.expiry {
+			s.size -= len(q.ps)
+			delete(s.queues, clientId)
+		}
+	}
+}
+
+// len returns the total number of the queued packets.
+func (s *MemStore) len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.size
+}
+
+// forEach calls the fn for every queued packet, the packets of each client
+// are visited in order.
+func (s *MemStore) forEach(fn func(clientId string, p *pkts.PubPkt)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for clientId, q := range s.queues {
+		for _, p := range q.ps {
+			fn(clientId, p)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package pubsub
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/happyxcj/gosocket/pkts"
+)
+
+func newPubPkt(topic, body string) *pkts.PubPkt {
+	return pkts.NewEasyPubPkt(topic, 1, []byte(body))
+}
+
+func drainBodies(t *testing.T, s Store, clientId, topic string) []string {
+	t.Helper()
+	ps, err := s.Drain(clientId, topic)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bodies := make([]string, len(ps))
+	for i, p := range ps {
+		bodies[i] = string(p.Body())
+	}
+	return bodies
+}
+
+func TestMemStoreMaxSize(t *testing.T) {
+	s := NewMemStore(MaxQueueSize(2))
+	for i := 0; i < 3; i++ {
+		s.Append("a", newPubPkt("t", strconv.Itoa(i)))
+	}
+	if got := drainBodies(t, s, "a", "t"); len(got) != 2 || got[0] != "1" {
+		t.Fatalf("expect [1 2], got %v", got)
+	}
+	if s.len() != 0 {
+		t.Fatalf("expect empty, got %d", s.len())
+	}
+	if NewMemStore().maxSize != defaultMaxQueueSize {
+		t.Fatal("the queue size is not limited by default")
+	}
+}
+
+func TestMemStoreExpiry(t *testing.T) {
+	s := NewMemStore(QueueExpiry(10 * time.Millisecond))
+	s.Append("a", newPubPkt("t", "1"))
+	time.Sleep(20 * time.Millisecond)
+	s.Append("b", newPubPkt("t", "2"))
+	if got := drainBodies(t, s, "a", "t"); len(got) != 0 {
+		t.Fatalf("the expired queue is kept: %v", got)
+	}
+	if got := drainBodies(t, s, "b", "t"); len(got) != 1 {
+		t.Fatalf("expect [2], got %v", got)
+	}
+}
+
+func TestFileStoreTruncated(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "store")
+	s, err := OpenFileStore(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Append("a", newPubPkt("t", "1"))
+	s.Append("a", newPubPkt("t", "2"))
+	s.Close()
+	// Simulate a crash in the middle of writing the last record.
+	info, _ := os.Stat(name)
+	if err = os.Truncate(name, info.Size()-1); err != nil {
+		t.Fatal(err)
+	}
+	if s, err = OpenFileStore(name); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if got := drainBodies(t, s, "a", "t"); len(got) != 1 || got[0] != "1" {
+		t.Fatalf("expect [1], got %v", got)
+	}
+}
+
+func TestFileStoreCompact(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "store")
+	s, err := OpenFileStore(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < minCompactRecords; i++ {
+		s.Append("a", newPubPkt("t", strconv.Itoa(i)))
+		s.Drain("a", "t")
+	}
+	s.Append("a", newPubPkt("t", "x"))
+	s.Append("a", newPubPkt("u", "y"))
+	s.Drain("a", "t")
+	if s.records > 2*s.mem.len()+2 {
+		t.Fatalf("the file is not compacted, %d records", s.records)
+	}
+	s.Append("a", newPubPkt("t", "z"))
+	s.Close()
+
+	if s, err = OpenFileStore(name); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if s.records != 2 {
+		t.Fatalf("the file is not compacted on opening, %d records", s.records)
+	}
+	if got := drainBodies(t, s, "a", "u"); len(got) != 1 || got[0] != "y" {
+		t.Fatalf("expect [y], got %v", got)
+	}
+	if got := drainBodies(t, s, "a", "t"); len(got) != 1 || got[0] != "z" {
+		t.Fatalf("expect [z], got %v", got)
+	}
+}
@@ -0,0 +1,94 @@
+package gosocket
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrNoAddress is returned when a resolver resolves no address.
+var ErrNoAddress = errors.New("no address is resolved")
+
+// Resolver resolves the addresses of the servers, the client re-resolves them
+// periodically, see ResolveInterval.
+type Resolver interface {
+
+	// Resolve returns the current addresses of the servers.
+	Resolve() ([]string, error)
+}
+
+// StaticResolver is a Resolver with a fixed list of addresses.
+type StaticResolver []string
+
+// ParseStaticResolver returns a StaticResolver for the comma separated addresses.
+func ParseStaticResolver(addrs string) StaticResolver {
+	var r StaticResolver
+	for _, addr := range strings.Split(addrs, ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			r = append(r, addr)
+		}
+	}
+	return r
+}
+
+func (r StaticResolver) Resolve() ([]string, error) {
+	if len(r) == 0 {
+		return nil, ErrNoAddress
+	}
+	return r, nil
+}
+
+// FileResolver is a Resolver that reads the addresses from a file.
+//
+// It doesn't watch the file for changes, it's polled by the client at each
+// ResolveInterval instead, so a change is picked up at the next re-resolving.
+// The file is read again only if it has been modified since the last reading.
+//
+// Each line of the file is an address, the empty lines and the lines
+// starting with '#' are ignored.
+type FileResolver struct {
+	path string
+
+	mu      sync.Mutex
+	modTime time.Time
+	size    int64
+	addrs   []string
+}
+
+func NewFileResolver(path string) *FileResolver {
+	return &FileResolver{path: path}
+}
+
+func (r *FileResolver) Resolve() ([]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	info, err := os.Stat(r.path)
+	if err != nil {
+		return nil, err
+	}
+	if r.addrs != nil && info.ModTime().Equal(r.modTime) && info.Size() == r.size {
+		return r.addrs, nil
+	}
+	data, err := os.ReadFile(r.path)
+	if err != nil {
+		return nil, err
+	}
+	var addrs []string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		addrs = append(addrs, line)
+	}
+	if len(addrs) == 0 {
+		return nil, ErrNoAddress
+	}
+	r.modTime, r.size, r.addrs = info.ModTime(), info.Size(), addrs
+	return addrs, nil
+}
@@ -1,33 +1,48 @@
 package route
 
 import (
+	"context"
 	"math"
 	"fmt"
 	"github.com/happyxcj/gosocket"
+	"github.com/happyxcj/gosocket/pkts"
 	"github.com/happyxcj/gosocket/protocol"
 )
 
 const abortIndex int8 = math.MaxInt8 / 2
 
+// Context is the context for handling a packet.
+//
+// It's reused for the other packets after the handlers return, so it must not
+// be retained by the handlers, use c.Context for the standard context instead.
 type Context struct {
 	Conn     gosocket.Conn
 	Pkt      protocol.Packet
 	Msg      interface{}
 	index    int8
 	handlers []HandlerFunc
+	// ctx is the standard context for handling the packet.
+	ctx context.Context
+	// validate validates the decoded message of the route, it's optional.
+	validate ValidateFunc
+	// bound is the message decoded and validated by the router, see bind.
+	bound interface{}
+	// inflight is the in-flight request if the packet is a ReqRespPkt.
+	inflight *inflight
 	// values is a key/value pair exclusively for the context of each request.
 	//
 	// It it usually used to store a value first using c.Set method so that this context
 	// can read the value later using c.Get or c.MustGet method.
-	values map[Key]interface{}
+	values map[interface{}]interface{}
 }
 
 type HandlerFunc func(*Context)
 
+// Key is a convenient type for the keys of the values, any comparable type can be used.
 type Key int
 
 func NewContext() *Context {
-	ctx := &Context{index: -1}
+	ctx := &Context{index: -1, ctx: context.Background()}
 	return ctx
 }
 
@@ -37,9 +52,35 @@ func (c *Context) Reset(conn gosocket.Conn, pkt protocol.Packet, msg interface{}
 	c.Msg = msg
 	c.handlers = handlers
 	c.index = -1
+	c.ctx = connContext(conn)
+	c.validate = nil
+	c.bound = nil
+	c.inflight = nil
 	c.values = nil
 }
 
+// connContext returns the context of the conn's lifetime if it exists.
+func connContext(conn gosocket.Conn) context.Context {
+	if cc, ok := conn.(interface{ Context() context.Context }); ok {
+		return cc.Context()
+	}
+	return context.Background()
+}
+
+// Context returns the standard context for handling the packet, which is derived
+// from the lifetime of the connection if the connection provides one, such as
+// gosocket.QueueConn, so it's cancelled when the connection closes or the packet
+// is handled. Unlike the c, it can be used after the handlers return.
+func (c *Context) Context() context.Context {
+	return c.ctx
+}
+
+// SetContext replaces the standard context for handling the packet,
+// the ctx should be derived from the current one.
+func (c *Context) SetContext(ctx context.Context) {
+	c.ctx = ctx
+}
+
 // IsAborted returns true if the current context was aborted.
 func (c *Context) IsAborted() bool {
 	return c.index >= abortIndex
@@ -73,24 +114,91 @@ func (c *Context) Next() {
 
 // Set is used to store a new key/value pair exclusively for this context.
 // It also lazy initializes c.values if it was not used previously.
-func (c *Context) Set(key Key, v interface{}) {
+// The key must be comparable.
+func (c *Context) Set(key interface{}, v interface{}) {
 	if c.values == nil {
-		c.values = make(map[Key]interface{})
+		c.values = make(map[interface{}]interface{})
 	}
 	c.values[key] = v
 }
 
 // Get returns the value for the given key (i.e., (value, true)).
 // If the value does not exist it returns (nil, false)
-func (c *Context) Get(key Key) (interface{}, bool) {
+func (c *Context) Get(key interface{}) (interface{}, bool) {
 	v, exist := c.values[key]
 	return v, exist
 }
 
 // MustGet returns the value for the given key if it exists, otherwise it panics.
-func (c *Context) MustGet(key Key) interface{} {
+func (c *Context) MustGet(key interface{}) interface{} {
 	if v, ok := c.Get(key); ok {
 		return v
 	}
 	panic(fmt.Sprintf("key %v does not exist", key))
 }
+
+// Decode decodes the application message of the packet by the codec of the packet,
+// and stores the result in the value pointed to by the v.
+// The result is validated by c.Validate after decoding.
+// It does nothing if the v is the message already decoded by the router.
+func (c *Context) Decode(v interface{}) error {
+	if v != nil && v == c.bound {
+		return nil
+	}
+	pkt, ok := c.Pkt.(pkts.DataPkt)
+	if !ok {
+		return ErrNotDataPkt
+	}
+	if err := pkts.DecodeBody(pkt, v); err != nil {
+		return err
+	}
+	return c.Validate(v)
+}
+
+// Reply sends a response with the v encoded by the codec of the request packet.
+// The response has no application message if the v is nil.
+func (c *Context) Reply(v interface{}) error {
+	resp, err := c.newResp()
+	if err != nil {
+		return err
+	}
+	if v != nil {
+		if err = pkts.EncodeBody(resp, v); err != nil {
+			return err
+		}
+	}
+	return c.Conn.Send(resp)
+}
+
+// ReplyError sends a response with the Status converted from the err,
+// see pkts.ToStatus for the detail of the conversion.
+func (c *Context) ReplyError(err error) error {
+	return c.ReplyStatus(pkts.ToStatus(err))
+}
+
+// ReplyStatus sends a response with the st.
+func (c *Context) ReplyStatus(st *pkts.Status) error {
+	resp, err := c.newResp()
+	if err != nil {
+		return err
+	}
+	pkts.SetStatus(resp, st)
+	return c.Conn.Send(resp)
+}
+
+// replyRequest sends a response with the st only if the packet is a request,
+// see pkts.AsRequest. The other packets rejected by the router or middlewares are dropped.
+func (c *Context) replyRequest(st *pkts.Status) {
+	if _, ok := pkts.AsRequest(c.Pkt); ok {
+		c.ReplyStatus(st)
+	}
+}
+
+// newResp returns an empty response for the request packet.
+func (c *Context) newResp() (pkts.ReqRespPkt, error) {
+	req, ok := c.Pkt.(pkts.ReqRespPkt)
+	if !ok {
+		return nil, ErrNotReqRespPkt
+	}
+	return pkts.NewRespPkt(req)
+}
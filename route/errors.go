@@ -0,0 +1,18 @@
+package route
+
+import "errors"
+
+var (
+
+	// ErrNotDataPkt signals that the packet has no application message.
+	ErrNotDataPkt = errors.New("the packet is not a data packet")
+
+	// ErrNotReqRespPkt signals that the packet can't be responded.
+	ErrNotReqRespPkt = errors.New("the packet is not a request packet")
+
+	// ErrInvalidMsgId signals that the message id can't be parsed to a RouteKey.
+	ErrInvalidMsgId = errors.New("invalid message id")
+
+	// ErrStreamEnded signals that the stream of responses has been ended.
+	ErrStreamEnded = errors.New("the stream has been ended")
+)
@@ -0,0 +1,121 @@
+package route
+
+import (
+	"context"
+	"sync"
+
+	"github.com/happyxcj/gosocket"
+)
+
+// inflightKey identifies an in-flight request.
+type inflightKey struct {
+	conn  gosocket.Conn
+	seqId uint16
+}
+
+// inflight represents an in-flight request that can be cancelled by a CancelPkt.
+type inflight struct {
+	cancel context.CancelFunc
+
+	// limited indicates whether the responses of the request are limited by the credits,
+	// which are granted by the property "PropWindow" of the request and WindowPkt.
+	limited bool
+	mu      sync.Mutex
+	credits uint32
+	// granted is signalled when the credits are granted.
+	granted chan struct{}
+}
+
+func newInflight(cancel context.CancelFunc, limited bool, credits uint32) *inflight {
+	return &inflight{cancel: cancel, limited: limited, credits: credits, granted: make(chan struct{}, 1)}
+}
+
+// grant adds the n credits.
+func (f *inflight) grant(n uint32) {
+	f.mu.Lock()
+	f.credits += n
+	f.mu.Unlock()
+	select {
+	case f.granted <- struct{}{}:
+	default:
+	}
+}
+
+// acquire takes a credit, it waits until a credit is granted or the ctx is done.
+func (f *inflight) acquire(ctx context.Context) error {
+	if !f.limited {
+		return nil
+	}
+	for {
+		f.mu.Lock()
+		if f.credits > 0 {
+			f.credits--
+			f.mu.Unlock()
+			return nil
+		}
+		f.mu.Unlock()
+		select {
+		case <-f.granted:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// inflights contains the in-flight requests of all connections.
+type inflights struct {
+	mu sync.Mutex
+	m  map[inflightKey]*inflight
+}
+
+func (s *inflights) add(key inflightKey, f *inflight) {
+	s.mu.Lock()
+	if s.m == nil {
+		s.m = make(map[inflightKey]*inflight)
+	}
+	s.m[key] = f
+	s.mu.Unlock()
+}
+
+// remove removes the f if it's still the in-flight request of the key.
+func (s *inflights) remove(key inflightKey, f *inflight) {
+	s.mu.Lock()
+	if s.m[key] == f {
+		delete(s.m, key)
+	}
+	s.mu.Unlock()
+}
+
+func (s *inflights) get(key inflightKey) (*inflight, bool) {
+	s.mu.Lock()
+	f, ok := s.m[key]
+	s.mu.Unlock()
+	return f, ok
+}
+
+// cancel cancels the in-flight request of the key if it exists.
+func (s *inflights) cancel(key inflightKey) {
+	if f, ok := s.get(key); ok {
+		f.cancel()
+	}
+}
+
+// grant grants the n credits to the in-flight request of the key if it exists.
+func (s *inflights) grant(key inflightKey, n uint32) {
+	if f, ok := s.get(key); ok {
+		f.grant(n)
+	}
+}
+
+// count returns the number of the in-flight requests of the conn.
+func (s *inflights) count(conn gosocket.Conn) int {
+	s.mu.Lock()
+	n := 0
+	for key := range s.m {
+		if key.conn == conn {
+			n++
+		}
+	}
+	s.mu.Unlock()
+	return n
+}
@@ -0,0 +1,142 @@
+package route
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"unsafe"
+
+	"github.com/happyxcj/gosocket/protocol"
+)
+
+// noRouteId is the MsgId of the NoRoute handlers.
+const noRouteId = "*"
+
+// RouteDesc describes a registered route or the NoRoute handlers.
+type RouteDesc struct {
+	Key RouteKey `json:"key"`
+	// Kind is zero for the NoRoute handlers of the RouterCenter.
+	Kind  protocol.PktKind `json:"kind"`
+	MsgId string           `json:"msgId"`
+	// MsgType is the name of the message type, it's empty if the route has no message.
+	MsgType string `json:"msgType,omitempty"`
+	// NoRoute indicates the handlers are set by NoRoute, the MsgId of it is "*".
+	NoRoute bool `json:"noRoute,omitempty"`
+	// Handlers contains the names of all handlers including the middlewares in order.
+	Handlers []string `json:"handlers"`
+}
+
+// handlerNames contains the names set by Named keyed by the function values,
+// which are distinct for every closure returned by the middleware constructors.
+var handlerNames sync.Map
+
+// funcValue returns the pointer of the function value of the h.
+func funcValue(h HandlerFunc) unsafe.Pointer {
+	return *(*unsafe.Pointer)(unsafe.Pointer(&h))
+}
+
+// Named returns the h with the name, which is returned by HandlerName instead of
+// the name of its function, such as "RateLimit(10, 20)" for a middleware.
+// The names are never released, so it's intended for the handlers created once.
+func Named(name string, h HandlerFunc) HandlerFunc {
+	handlerNames.Store(funcValue(h), name)
+	return h
+}
+
+// HandlerName returns the name set by Named for the h, or the name of the function of it.
+func HandlerName(h HandlerFunc) string {
+	if name, ok := handlerNames.Load(funcValue(h)); ok {
+		return name.(string)
+	}
+	if f := runtime.FuncForPC(reflect.ValueOf(h).Pointer()); f != nil {
+		return f.Name()
+	}
+	return ""
+}
+
+// handlerNamesOf returns the names of the handlers.
+func handlerNamesOf(handlers []HandlerFunc) []string {
+	names := make([]string, len(handlers))
+	for i, h := range handlers {
+		names[i] = HandlerName(h)
+	}
+	return names
+}
+
+// Kinds returns the kinds of all groups in ascending order.
+func (rc *RouterCenter) Kinds() []protocol.PktKind {
+	kinds := make([]protocol.PktKind, 0, len(rc.routers))
+	for kind := range rc.routers {
+		kinds = append(kinds, kind)
+	}
+	sort.Slice(kinds, func(i, j int) bool { return kinds[i] < kinds[j] })
+	return kinds
+}
+
+// Routes returns all registered routes ordered by the key, the NoRoute handlers
+// of every group follow its routes, and the ones of the RouterCenter are the last.
+func (rc *RouterCenter) Routes() []RouteDesc {
+	var routes []RouteDesc
+	for _, kind := range rc.Kinds() {
+		routes = append(routes, rc.routers[kind].Routes()...)
+	}
+	if rc.noRoute != nil {
+		routes = append(routes, RouteDesc{MsgId: noRouteId, NoRoute: true, Handlers: handlerNamesOf(rc.noRoute)})
+	}
+	return routes
+}
+
+// Dump writes all registered routes to the w as a text table.
+func (rc *RouterCenter) Dump(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "KIND\tMSG ID\tMSG TYPE\tHANDLERS")
+	for _, r := range rc.Routes() {
+		kind := fmt.Sprint(r.Kind)
+		if r.Kind == 0 {
+			kind = noRouteId
+		}
+		fmt.Fprintf(tw, "%v\t%v\t%v\t%v\n", kind, r.MsgId, r.MsgType, strings.Join(r.Handlers, ", "))
+	}
+	return tw.Flush()
+}
+
+// DumpJSON writes all registered routes to the w as a JSON array.
+func (rc *RouterCenter) DumpJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	routes := rc.Routes()
+	if routes == nil {
+		routes = []RouteDesc{}
+	}
+	return enc.Encode(routes)
+}
+
+// Kind returns the packet kind of the group.
+func (rg *RouterGroup) Kind() protocol.PktKind {
+	return rg.kind
+}
+
+// Routes returns all registered routes of the group ordered by the key,
+// followed by the NoRoute handlers of the group if they are set.
+func (rg *RouterGroup) Routes() []RouteDesc {
+	routes := make([]RouteDesc, 0, len(rg.routes)+1)
+	for key, info := range rg.routes {
+		r := RouteDesc{Key: key, Kind: rg.kind, MsgId: key.String(), Handlers: handlerNamesOf(info.handlers)}
+		if info.msgType != nil {
+			r.MsgType = info.msgType.String()
+		}
+		routes = append(routes, r)
+	}
+	sort.Slice(routes, func(i, j int) bool { return routes[i].Key.less(routes[j].Key) })
+	if rg.noRoute != nil {
+		routes = append(routes, RouteDesc{Key: RouteKey{Kind: rg.kind}, Kind: rg.kind, MsgId: noRouteId,
+			NoRoute: true, Handlers: handlerNamesOf(rg.noRoute)})
+	}
+	return routes
+}
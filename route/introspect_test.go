@@ -0,0 +1,58 @@
+package route
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/happyxcj/gosocket/pkts"
+)
+
+func TestHandlerName(t *testing.T) {
+	tests := []struct {
+		h    HandlerFunc
+		name string
+	}{
+		{RateLimit(10, 20), "RateLimit(10, 20)"},
+		{RateLimit(1, 2), "RateLimit(1, 2)"},
+		{MaxConcurrent(5), "MaxConcurrent(5)"},
+		{Timeout(time.Second), "Timeout(1s)"},
+		{Recovery(), "Recovery"},
+		{bindHandler, "Bind"},
+		{Named("custom", func(c *Context) {}), "custom"},
+	}
+	for _, tt := range tests {
+		if name := HandlerName(tt.h); name != tt.name {
+			t.Errorf("expect %q, got %q", tt.name, name)
+		}
+	}
+	if name := HandlerName(NotFound); !strings.HasSuffix(name, "route.NotFound") {
+		t.Errorf("expect the function name, got %q", name)
+	}
+}
+
+func TestRoutesNoRoute(t *testing.T) {
+	rc := NewRouterCenter()
+	rc.Use(Recovery())
+	rc.NoRoute(NotFound)
+	rc.Group(pkts.KindHttp).Use(Timeout(time.Second)).NoRoute(NotFound).Handle(5, nil, NotFound)
+
+	var got [][]string
+	for _, r := range rc.Routes() {
+		got = append(got, append([]string{r.MsgId}, r.Handlers...))
+	}
+	notFound := HandlerName(NotFound)
+	expect := [][]string{
+		{"5", "Recovery", "Timeout(1s)", notFound},
+		{"*", "Recovery", "Timeout(1s)", notFound},
+		{"*", "Recovery", notFound},
+	}
+	if !reflect.DeepEqual(got, expect) {
+		t.Fatalf("expect %v, got %v", expect, got)
+	}
+	rs := rc.Routes()
+	if !rs[1].NoRoute || rs[1].Kind != pkts.KindHttp || !rs[2].NoRoute || rs[2].Kind != 0 {
+		t.Fatalf("unexpected NoRoute descriptions: %+v", rs[1:])
+	}
+}
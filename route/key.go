@@ -0,0 +1,87 @@
+package route
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/happyxcj/gosocket/pkts"
+	"github.com/happyxcj/gosocket/protocol"
+)
+
+// RouteKey identifies a route, it's comparable and used as a map key directly,
+// so looking up a route doesn't allocate.
+//
+// For a packet without the application message, such as a PingPkt,
+// only the kind is used.
+type RouteKey struct {
+	Kind    protocol.PktKind
+	Cmd     uint16
+	Version byte
+	Codec   byte
+}
+
+// PktKey returns the RouteKey of the p.
+func PktKey(p protocol.Packet) RouteKey {
+	pkt, ok := p.(pkts.DataPkt)
+	if !ok {
+		return RouteKey{Kind: p.Kind()}
+	}
+	return RouteKey{Kind: p.Kind(), Cmd: pkt.Cmd(), Version: pkt.Version(), Codec: pkt.Codec()}
+}
+
+// ParseMsgId returns the RouteKey of the kind for the msgId generated by GenMsgId,
+// the formats are "ping", "cmd" and "cmd-version-codec".
+func ParseMsgId(kind protocol.PktKind, msgId string) (RouteKey, error) {
+	key := RouteKey{Kind: kind}
+	if msgId == "ping" {
+		return key, nil
+	}
+	parts := strings.Split(msgId, "-")
+	if len(parts) != 1 && len(parts) != 3 {
+		return key, ErrInvalidMsgId
+	}
+	cmd, err := strconv.ParseUint(parts[0], 10, 16)
+	if err != nil {
+		return key, ErrInvalidMsgId
+	}
+	key.Cmd = uint16(cmd)
+	if len(parts) == 1 {
+		return key, nil
+	}
+	version, err := strconv.ParseUint(parts[1], 10, 8-pkts.CodecBits)
+	if err != nil {
+		return key, ErrInvalidMsgId
+	}
+	codec, err := strconv.ParseUint(parts[2], 10, pkts.CodecBits)
+	if err != nil {
+		return key, ErrInvalidMsgId
+	}
+	key.Version, key.Codec = byte(version), byte(codec)
+	return key, nil
+}
+
+// String returns the message id of the k, see GenMsgId.
+func (k RouteKey) String() string {
+	if k.Kind == pkts.KindPing {
+		return "ping"
+	}
+	if k.Version == 0 && k.Codec == 0 {
+		return strconv.Itoa(int(k.Cmd))
+	}
+	return fmt.Sprintf("%v-%v-%v", k.Cmd, k.Version, k.Codec)
+}
+
+// less reports whether the k sorts before the other.
+func (k RouteKey) less(other RouteKey) bool {
+	if k.Kind != other.Kind {
+		return k.Kind < other.Kind
+	}
+	if k.Cmd != other.Cmd {
+		return k.Cmd < other.Cmd
+	}
+	if k.Version != other.Version {
+		return k.Version < other.Version
+	}
+	return k.Codec < other.Codec
+}
@@ -0,0 +1,188 @@
+package route
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/happyxcj/gosocket/pkts"
+)
+
+// idleBucketTimeout is the interval to remove the idle token buckets.
+const idleBucketTimeout = time.Minute
+
+// KeyFunc returns the key to classify the packet for the limits.
+// The packet is not limited if it returns false.
+type KeyFunc func(c *Context) (interface{}, bool)
+
+// ByConn classifies the packets by the connection.
+func ByConn(c *Context) (interface{}, bool) {
+	return c.Conn, true
+}
+
+// ByRoute classifies the packets by the route.
+func ByRoute(c *Context) (interface{}, bool) {
+	return PktKey(c.Pkt), true
+}
+
+// ByGlobal classifies all packets into one.
+func ByGlobal(c *Context) (interface{}, bool) {
+	return nil, true
+}
+
+// ByValue returns a KeyFunc to classify the packets by the value stored by c.Set
+// for the key, such as the identity of an authenticated user.
+// The packets without the value are not limited.
+func ByValue(key interface{}) KeyFunc {
+	return func(c *Context) (interface{}, bool) {
+		return c.Get(key)
+	}
+}
+
+type LimitOpts struct {
+	// key classifies the packets, the packets with the same key share the limit.
+	key KeyFunc
+	// onReject is the callback when a packet is rejected.
+	onReject func(c *Context)
+}
+
+type LimitOpt func(*LimitOpts)
+
+// LimitKey returns a LimitOpt to set the KeyFunc to classify the packets.
+func LimitKey(key KeyFunc) LimitOpt {
+	return func(o *LimitOpts) {
+		o.key = key
+	}
+}
+
+// OnLimited returns a LimitOpt to set the callback when a packet is rejected,
+// it's usually used to count the rejected packets.
+func OnLimited(onReject func(*Context)) LimitOpt {
+	return func(o *LimitOpts) {
+		o.onReject = onReject
+	}
+}
+
+func newLimitOpts(key KeyFunc, opts []LimitOpt) *LimitOpts {
+	o := &LimitOpts{key: key}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// reject aborts the c and replies with the status "StatusRateLimited" if the packet is
+// a request, the other packets are dropped.
+func (o *LimitOpts) reject(c *Context, msg string) {
+	c.Abort()
+	if o.onReject != nil {
+		o.onReject(c)
+	}
+	c.replyRequest(pkts.NewStatus(pkts.StatusRateLimited, msg))
+}
+
+// RateLimit returns a middleware that limits the rate of the packets by token buckets.
+// Each bucket is refilled at the rate of tokens per second and holds at most burst tokens,
+// a packet takes a token from the bucket of its key, or it's rejected.
+//
+// The packets are classified by ByConn unless the LimitKey is set. It's used as
+// a limit per route if it's registered for a specified route with ByGlobal.
+func RateLimit(rate float64, burst int, opts ...LimitOpt) HandlerFunc {
+	o := newLimitOpts(ByConn, opts)
+	l := &rateLimiter{rate: rate, burst: float64(burst), buckets: make(map[interface{}]*tokenBucket)}
+	return Named(fmt.Sprintf("RateLimit(%v, %v)", rate, burst), func(c *Context) {
+		key, ok := o.key(c)
+		if !ok {
+			return
+		}
+		if !l.allow(key, time.Now()) {
+			o.reject(c, "too many requests")
+		}
+	})
+}
+
+// tokenBucket is a token bucket protected by the lock of its rateLimiter.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+type rateLimiter struct {
+	rate    float64
+	burst   float64
+	mu      sync.Mutex
+	buckets map[interface{}]*tokenBucket
+	// lastSweep is the last time to remove the idle buckets.
+	lastSweep time.Time
+}
+
+func (l *rateLimiter) allow(key interface{}, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if now.Sub(l.lastSweep) > idleBucketTimeout {
+		l.sweep(now)
+	}
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens += now.Sub(b.last).Seconds() * l.rate
+	if b.tokens > l.burst {
+		b.tokens = l.burst
+	}
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// sweep removes the buckets not used for a while with the lock held,
+// so the buckets of the closed connections are released.
+// A bucket is removed only if it has been refilled, since a new bucket is full.
+func (l *rateLimiter) sweep(now time.Time) {
+	l.lastSweep = now
+	for key, b := range l.buckets {
+		elapsed := now.Sub(b.last)
+		if elapsed > idleBucketTimeout && b.tokens+elapsed.Seconds()*l.rate >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// MaxConcurrent returns a middleware that limits the number of the packets
+// handled concurrently by the subsequent handlers, the excess packets are rejected.
+//
+// The packets are classified by ByGlobal unless the LimitKey is set, so it's
+// a limit per route if it's registered for a specified route.
+// Note that the packets of a connection are handled one by one
+// unless they are handled concurrently, see gosocket.ServerWorkers.
+func MaxConcurrent(n int, opts ...LimitOpt) HandlerFunc {
+	o := newLimitOpts(ByGlobal, opts)
+	var mu sync.Mutex
+	running := make(map[interface{}]int)
+	return Named(fmt.Sprintf("MaxConcurrent(%v)", n), func(c *Context) {
+		key, ok := o.key(c)
+		if !ok {
+			return
+		}
+		mu.Lock()
+		if running[key] >= n {
+			mu.Unlock()
+			o.reject(c, "too many concurrent requests")
+			return
+		}
+		running[key]++
+		mu.Unlock()
+		defer func() {
+			mu.Lock()
+			if running[key]--; running[key] == 0 {
+				delete(running, key)
+			}
+			mu.Unlock()
+		}()
+		c.Next()
+	})
+}
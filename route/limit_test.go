@@ -0,0 +1,28 @@
+package route
+
+import (
+	"testing"
+
+	"github.com/happyxcj/gosocket/pkts"
+)
+
+func TestRejectOnlyRequests(t *testing.T) {
+	rc := NewRouterCenter()
+	rc.Use(RateLimit(0, 0))
+	rc.Group(pkts.KindHttp).Handle(1, nil, func(c *Context) {})
+
+	c := &recordConn{}
+	rc.HandlePacket(c, pkts.NewEasyHttpPkt(1, 1, nil))
+	if code := c.status(t); code != pkts.StatusRateLimited {
+		t.Fatalf("expect status %d, got %d", pkts.StatusRateLimited, code)
+	}
+
+	// A response carrying a status is never replied.
+	c = &recordConn{}
+	resp := pkts.NewEasyHttpPkt(1, 1, nil)
+	pkts.SetStatus(resp, pkts.NewStatus(pkts.StatusNotFound, ""))
+	rc.HandlePacket(c, resp)
+	if len(c.sent) != 0 {
+		t.Fatalf("expect no reply to a response, got %d", len(c.sent))
+	}
+}
@@ -0,0 +1,79 @@
+package route
+
+import (
+	"fmt"
+	"runtime/debug"
+
+	"github.com/happyxcj/gosocket/pkts"
+)
+
+// PanicError represents a panic recovered from a handler.
+type PanicError struct {
+	// Value is the value passed to the panic.
+	Value interface{}
+	// Stack is the stack trace of the goroutine when the panic is recovered.
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic: %v", e.Value)
+}
+
+type RecoveryOpts struct {
+	// handler handles the recovered panic.
+	handler func(c *Context, err *PanicError)
+	// closeConn indicates whether to close the connection after the panic is handled.
+	closeConn bool
+}
+
+type RecoveryOpt func(*RecoveryOpts)
+
+// RecoveryHandler returns a RecoveryOpt to set the handler of the recovered panic.
+// The default is DefaultRecoveryHandler.
+func RecoveryHandler(handler func(*Context, *PanicError)) RecoveryOpt {
+	return func(o *RecoveryOpts) {
+		o.handler = handler
+	}
+}
+
+// RecoveryCloseConn returns a RecoveryOpt to set whether to close the connection
+// after the panic is handled. The default is false.
+func RecoveryCloseConn(closeConn bool) RecoveryOpt {
+	return func(o *RecoveryOpts) {
+		o.closeConn = closeConn
+	}
+}
+
+// DefaultRecoveryHandler prints the panic with the stack trace, and replies with
+// the status "StatusInternal" if the packet is a request.
+func DefaultRecoveryHandler(c *Context, err *PanicError) {
+	fmt.Printf("recovered from handling packet: %v, %v\n%s", c.Pkt.Kind(), err, err.Stack)
+	// The details of the panic are not exposed to the remote.
+	c.replyRequest(pkts.NewStatus(pkts.StatusInternal, ""))
+}
+
+// Recovery returns a middleware that recovers from any panic in the subsequent handlers,
+// the context is aborted after the panic is handled.
+//
+// It should be the first handler to recover from the panics in all handlers.
+// Note that it can't recover from the panics in the goroutines started by the handlers.
+func Recovery(opts ...RecoveryOpt) HandlerFunc {
+	o := &RecoveryOpts{handler: DefaultRecoveryHandler}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return Named("Recovery", func(c *Context) {
+		defer func() {
+			v := recover()
+			if v == nil {
+				return
+			}
+			c.Abort()
+			o.handler(c, &PanicError{Value: v, Stack: debug.Stack()})
+			if o.closeConn {
+				c.Conn.Close()
+			}
+		}()
+		c.Next()
+	})
+}
@@ -1,23 +1,17 @@
 package route
 
 import (
-	"sync"
-	"reflect"
 	"github.com/happyxcj/gosocket"
-	"github.com/happyxcj/gosocket/protocol"
 	"github.com/happyxcj/gosocket/pkts"
-	"fmt"
+	"github.com/happyxcj/gosocket/protocol"
 )
 
-var globalRC *RouterCenter
-
-var ctxPool sync.Pool
+// globalRC is the default RouterCenter used by the package-level functions.
+var globalRC = NewRouterCenter()
 
-func init() {
-	globalRC = NewRouterCenter()
-	ctxPool.New = func() interface{} {
-		return new(Context)
-	}
+// Default returns the default RouterCenter used by the package-level functions.
+func Default() *RouterCenter {
+	return globalRC
 }
 
 func Group(kind protocol.PktKind, handlers ...HandlerFunc) *RouterGroup {
@@ -32,45 +26,49 @@ func UseAfter(handlers ...HandlerFunc) *RouterCenter {
 	return globalRC.UseAfter(handlers...)
 }
 
+func NoRoute(handlers ...HandlerFunc) *RouterCenter {
+	return globalRC.NoRoute(handlers...)
+}
+
+func RegisterService(rcvr interface{}, cmds map[string]RouteKey) error {
+	return globalRC.RegisterService(rcvr, cmds)
+}
+
 func Get(kind protocol.PktKind, msgId string) (*RouterInfo, bool) {
 	return globalRC.Get(kind, msgId)
 }
 
+func Routes() []RouteDesc {
+	return globalRC.Routes()
+}
+
 func GetContext() *Context {
-	ctx := ctxPool.Get().(*Context)
-	return ctx
+	return globalRC.GetContext()
 }
 
 func PutContext(ctx *Context) {
-	ctxPool.Put(ctx)
+	globalRC.PutContext(ctx)
 }
 
-// HandlePacket handle the given p for the c.
+// HandlePacket handle the given p for the c by the default RouterCenter.
 // It Returns a bool indicates whether the p can be handled successfully.
 func HandlePacket(c gosocket.Conn, p protocol.Packet) bool {
-	info, ok := Get(p.Kind(), GenMsgId(p))
-	if !ok {
-		return false
-	}
-	var msg interface{}
-	if info.msgType != nil {
-		msg = reflect.New(info.msgType).Interface()
-	}
-	ctx := GetContext()
-	ctx.Reset(c, p, msg, info.handlers)
-	ctx.Next()
-	PutContext(ctx)
-	return true
+	return globalRC.HandlePacket(c, p)
+}
+
+// NotFound replies with the status "StatusNotFound" if the packet is a request,
+// see pkts.AsRequest, the responses are never replied.
+//
+// A server replies to the requests without any NoRoute handler in the same way
+// by default, see gosocket.ServerReplyUnknown, so it's usually used in the NoRoute
+// handlers of a group after the other handlers. It must not be used by the router
+// of a client, which receives the late responses of the requests given up.
+func NotFound(c *Context) {
+	c.replyRequest(pkts.Errorf(pkts.StatusNotFound, "unknown command: %v", GenMsgId(c.Pkt)))
 }
 
 // GenMsgId returns a unique id of the packet application message.
+// It's the string form of the RouteKey, use PktKey on the hot path instead.
 func GenMsgId(p protocol.Packet) string {
-	if p.Kind() == pkts.KindPing {
-		return "ping"
-	}
-	pkt := p.(pkts.DataPkt)
-	if pkt.Version() == 0 && pkt.Codec() == 0 {
-		return fmt.Sprint(pkt.Cmd())
-	}
-	return fmt.Sprintf("%v-%v-%v", pkt.Cmd(), pkt.Version(), pkt.Codec())
-}
\ No newline at end of file
+	return PktKey(p).String()
+}
@@ -1,21 +1,226 @@
 package route
 
 import (
+	"context"
 	"reflect"
 	"fmt"
+	"sync"
+	"time"
+	"github.com/happyxcj/gosocket"
+	"github.com/happyxcj/gosocket/pkts"
 	"github.com/happyxcj/gosocket/protocol"
 )
 
+var (
+	_ gosocket.Router  = (*RouterCenter)(nil)
+	_ gosocket.Drainer = (*RouterCenter)(nil)
+)
+
+const defaultMaxStreams = 1024
+
+// RouterCenter routes the packets to the handlers registered in its groups.
+// All routes should be registered before handling any packet.
 type RouterCenter struct {
 	handlers []HandlerFunc
 	headsNum int
 	routers  map[protocol.PktKind]*RouterGroup
+	// noRoute handles the packets without any matched route
+	// if their groups have no own noRoute handlers.
+	noRoute []HandlerFunc
+	ctxPool sync.Pool
+	// inflights contains the requests being handled, they can be cancelled by CancelPkt.
+	inflights inflights
+	// streams limits the number of the streaming requests handled concurrently.
+	streams chan struct{}
 }
 
 func NewRouterCenter() *RouterCenter {
-	return &RouterCenter{
+	rc := &RouterCenter{
 		routers: make(map[protocol.PktKind]*RouterGroup),
+		streams: make(chan struct{}, defaultMaxStreams),
+	}
+	rc.ctxPool.New = func() interface{} {
+		return new(Context)
+	}
+	return rc
+}
+
+// GetContext returns a Context from the context pool.
+func (rc *RouterCenter) GetContext() *Context {
+	return rc.ctxPool.Get().(*Context)
+}
+
+// PutContext puts the ctx to the context pool.
+func (rc *RouterCenter) PutContext(ctx *Context) {
+	rc.ctxPool.Put(ctx)
+}
+
+// HandlePacket handle the given p for the c.
+// It Returns a bool indicates whether the p can be handled successfully.
+//
+// If the p has no matched route, it's handled by the NoRoute handlers of its group
+// or the RouterCenter, or not handled without any NoRoute handler, then a server
+// replies to it with the status "StatusNotFound" unless gosocket.ServerReplyUnknown
+// is disabled, see NotFound for the NoRoute handlers of the groups.
+//
+// The context of a request with the property "PropDeadline" is cancelled at the deadline,
+// and the request is dropped if it has expired. The context of a request is also cancelled
+// when a CancelPkt with the same sequence id is received from the c, which requires
+// the packets of the c are handled concurrently, see gosocket.ServerWorkers.
+//
+// A request with the flag "FlagStream" is handled in a new goroutine, see Context.ReplyStream.
+// The streaming requests beyond the limit of MaxStreams are replied with the status
+// "StatusRateLimited".
+func (rc *RouterCenter) HandlePacket(c gosocket.Conn, p protocol.Packet) bool {
+	switch pkt := p.(type) {
+	case *pkts.CancelPkt:
+		rc.inflights.cancel(inflightKey{conn: c, seqId: pkt.SeqId()})
+		return true
+	case *pkts.WindowPkt:
+		rc.inflights.grant(inflightKey{conn: c, seqId: pkt.SeqId()}, pkt.Credits())
+		return true
+	}
+	rg, info, ok := rc.match(p)
+	if ok {
+		var msg interface{}
+		if info.msgType != nil {
+			msg = reflect.New(info.msgType).Interface()
+		}
+		if p.Flags().Has(pkts.FlagStream) {
+			rc.handleStream(c, p, msg, info)
+			return true
+		}
+		rc.handle(c, p, msg, info.handlers, info.validate)
+		return true
+	}
+	switch {
+	case rg != nil && rg.noRoute != nil:
+		rc.handle(c, p, nil, rg.noRoute, nil)
+	case rc.noRoute != nil:
+		rc.handle(c, p, nil, rc.noRoute, nil)
+	default:
+		return false
+	}
+	return true
+}
+
+// Inflight returns the number of the in-flight requests received from the c,
+// including the ones handled in new goroutines.
+func (rc *RouterCenter) Inflight(c gosocket.Conn) int {
+	return rc.inflights.count(c)
+}
+
+// MaxStreams sets the max number of the streaming requests handled concurrently,
+// see HandlePacket. It's 1024 by default.
+func (rc *RouterCenter) MaxStreams(n int) *RouterCenter {
+	rc.streams = make(chan struct{}, n)
+	return rc
+}
+
+// handleStream handles the streaming request p in a new goroutine, so the WindowPkt
+// can be received while the handlers are waiting for the credits.
+// The request is registered before the goroutine starts, so it can be cancelled
+// by a CancelPkt received at any time.
+func (rc *RouterCenter) handleStream(c gosocket.Conn, p protocol.Packet, msg interface{}, info *RouterInfo) {
+	select {
+	case rc.streams <- struct{}{}:
+	default:
+		if req, ok := pkts.AsRequest(p); ok {
+			if resp, err := pkts.NewRespPkt(req); err == nil {
+				pkts.SetStatus(resp, pkts.NewStatus(pkts.StatusRateLimited, "too many streams"))
+				c.Send(resp)
+			}
+		}
+		return
+	}
+	run, ok := rc.begin(c, p, msg, info.handlers, info.validate)
+	if !ok {
+		<-rc.streams
+		return
 	}
+	go func() {
+		defer func() { <-rc.streams }()
+		run()
+	}()
+}
+
+func (rc *RouterCenter) handle(c gosocket.Conn, p protocol.Packet, msg interface{}, handlers []HandlerFunc, validate ValidateFunc) {
+	if run, ok := rc.begin(c, p, msg, handlers, validate); ok {
+		run()
+	}
+}
+
+// begin registers the in-flight request of the p, and returns the function to
+// run the handlers for the p. It returns false if the p has expired.
+func (rc *RouterCenter) begin(c gosocket.Conn, p protocol.Packet, msg interface{}, handlers []HandlerFunc, validate ValidateFunc) (func(), bool) {
+	parent := connContext(c)
+	var cancel context.CancelFunc
+	if deadline, ok := reqDeadline(p); ok {
+		if !deadline.After(time.Now()) {
+			// The client has given up the expired request.
+			return nil, false
+		}
+		parent, cancel = context.WithDeadline(parent, deadline)
+	} else {
+		parent, cancel = context.WithCancel(parent)
+	}
+	var f *inflight
+	var key inflightKey
+	if req, ok := p.(pkts.ReqRespPkt); ok {
+		window, limited := req.Props().GetUint32(pkts.PropWindow)
+		f = newInflight(cancel, limited, window)
+		key = inflightKey{conn: c, seqId: req.SeqId()}
+		rc.inflights.add(key, f)
+	}
+	return func() {
+		if f != nil {
+			defer rc.inflights.remove(key, f)
+		}
+		ctx := rc.GetContext()
+		ctx.Reset(c, p, msg, handlers)
+		ctx.ctx = parent
+		ctx.validate = validate
+		ctx.inflight = f
+		ctx.Next()
+		cancel()
+		rc.PutContext(ctx)
+	}, true
+}
+
+// reqDeadline returns the deadline of the p if it's a ReqRespPkt with a deadline.
+func reqDeadline(p protocol.Packet) (time.Time, bool) {
+	req, ok := p.(pkts.ReqRespPkt)
+	if !ok {
+		return time.Time{}, false
+	}
+	return pkts.GetDeadline(req)
+}
+
+// match returns the route of the p and its group.
+// If there is no route for the cmd with the version and codec of the p,
+// the route for the plain cmd is used.
+func (rc *RouterCenter) match(p protocol.Packet) (*RouterGroup, *RouterInfo, bool) {
+	rg, ok := rc.routers[p.Kind()]
+	if !ok {
+		return nil, nil, false
+	}
+	key := PktKey(p)
+	if info, ok := rg.routes[key]; ok {
+		return rg, info, true
+	}
+	if key.Version == 0 && key.Codec == 0 {
+		return rg, nil, false
+	}
+	key.Version, key.Codec = 0, 0
+	info, ok := rg.routes[key]
+	return rg, info, ok
+}
+
+// NoRoute sets the handlers for the packets without any matched route,
+// they are combined with the current middlewares of the RouterCenter.
+func (rc *RouterCenter) NoRoute(handlers ...HandlerFunc) *RouterCenter {
+	rc.noRoute = combineHandlers(rc.handlers, rc.headsNum, handlers...)
+	return rc
 }
 
 func (rc *RouterCenter) Use(handlers ...HandlerFunc) *RouterCenter {
@@ -44,12 +249,29 @@ func (rc *RouterCenter) Group(kind protocol.PktKind, handlers ...HandlerFunc) *R
 	return rg
 }
 
+// GroupOf returns the group of the kind, it creates one by Group if not exist.
+func (rc *RouterCenter) GroupOf(kind protocol.PktKind) *RouterGroup {
+	if rg, ok := rc.routers[kind]; ok {
+		return rg
+	}
+	return rc.Group(kind)
+}
+
 func (rc *RouterCenter) Get(kind protocol.PktKind, msgId string) (*RouterInfo, bool) {
-	router, ok := rc.routers[kind]
+	key, err := ParseMsgId(kind, msgId)
+	if err != nil {
+		return nil, false
+	}
+	return rc.GetKey(key)
+}
+
+// GetKey returns the route for the key if it exists.
+func (rc *RouterCenter) GetKey(key RouteKey) (*RouterInfo, bool) {
+	router, ok := rc.routers[key.Kind]
 	if !ok {
 		return nil, false
 	}
-	info, ok := router.routes[msgId]
+	info, ok := router.routes[key]
 	return info, ok
 }
 
@@ -57,12 +279,16 @@ type RouterGroup struct {
 	kind     protocol.PktKind
 	handlers []HandlerFunc
 	headsNum int
-	routes   map[string]*RouterInfo
+	routes   map[RouteKey]*RouterInfo
+	// noRoute handles the packets of the kind without any matched route.
+	noRoute []HandlerFunc
 }
 
 type RouterInfo struct {
 	msgType  reflect.Type
 	handlers []HandlerFunc
+	// validate validates the decoded message, it's optional.
+	validate ValidateFunc
 }
 
 func (rg *RouterGroup) Group() *RouterGroup {
@@ -86,12 +312,53 @@ func (rg *RouterGroup) UseAfter(handlers ...HandlerFunc) *RouterGroup {
 	return rg
 }
 
+// NoRoute sets the handlers for the packets of the kind without any matched route,
+// they are combined with the current middlewares of the RouterGroup.
+func (rg *RouterGroup) NoRoute(handlers ...HandlerFunc) *RouterGroup {
+	rg.noRoute = combineHandlers(rg.handlers, rg.headsNum, handlers...)
+	return rg
+}
+
+// Handle registers the handlers for the msgId, which is a RouteKey or
+// a value whose string form is a message id generated by GenMsgId, such as
+// 1000 or "1000-1-0". It panics if the msgId is invalid.
+//
+// If the msg is not nil, the application message of a packet is decoded into
+// a new value of the msg's type by the codec of the packet and validated after
+// the middlewares and before the handlers, and c.Msg is set to the pointer to it.
+// The packet fails the decoding or validation is replied with the Status converted
+// by BadRequest or InvalidArgument if it's a ReqRespPkt, and never reaches the handlers.
 func (rg *RouterGroup) Handle(msgId, msg interface{}, handlers ...HandlerFunc) *RouterGroup {
+	return rg.handle(rg.parseKey(msgId), msg, handlers...)
+}
+
+// parseKey returns the RouteKey of the msgId, it panics if the msgId is invalid.
+func (rg *RouterGroup) parseKey(msgId interface{}) RouteKey {
+	if key, ok := msgId.(RouteKey); ok {
+		key.Kind = rg.kind
+		return key
+	}
+	key, err := ParseMsgId(rg.kind, fmt.Sprint(msgId))
+	if err != nil {
+		panic(fmt.Sprintf("invalid message id '%v'", msgId))
+	}
+	return key
+}
+
+// HandleCmd registers the handlers for the cmd with the version and codec.
+func (rg *RouterGroup) HandleCmd(cmd uint16, version, codec byte, msg interface{}, handlers ...HandlerFunc) *RouterGroup {
+	return rg.handle(RouteKey{Kind: rg.kind, Cmd: cmd, Version: version, Codec: codec}, msg, handlers...)
+}
+
+func (rg *RouterGroup) handle(key RouteKey, msg interface{}, handlers ...HandlerFunc) *RouterGroup {
+	if msg != nil {
+		handlers = append([]HandlerFunc{bindHandler}, handlers...)
+	}
 	handlers = combineHandlers(rg.handlers, rg.headsNum, handlers...)
 	if rg.routes == nil {
-		rg.routes = make(map[string]*RouterInfo)
+		rg.routes = make(map[RouteKey]*RouterInfo)
 	}
-	rg.routes[fmt.Sprint(msgId)] = &RouterInfo{
+	rg.routes[key] = &RouterInfo{
 		handlers: handlers,
 		msgType:  reflect.TypeOf(msg),
 	}
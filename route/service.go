@@ -0,0 +1,128 @@
+package route
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/happyxcj/gosocket/pkts"
+)
+
+// serviceTag is the struct tag to map the methods of a service to the commands.
+const serviceTag = "gosocket"
+
+var (
+	typeOfContext = reflect.TypeOf((*context.Context)(nil)).Elem()
+	typeOfError   = reflect.TypeOf((*error)(nil)).Elem()
+)
+
+// RegisterService registers the exported methods of the rcvr as the routes of the kind
+// "KindHttp", it's similar to the net/rpc. Each method must look schematically like:
+//
+//	func (t *T) MethodName(ctx context.Context, req *Req) (*Resp, error)
+//
+// The ctx is the standard context of the packet, see Context.Context. The request
+// message is decoded into a Req and validated before the method is called,
+// and the result is replied like HandleTyped.
+//
+// The methods are mapped to the commands by the cmds, and by the struct tags of the
+// fields of the rcvr, such as:
+//
+//	type T struct {
+//		_ struct{} `gosocket:"MethodName=1000,OtherMethod=1001-1-0"`
+//	}
+//
+// The methods without any command are ignored. It returns an error
+// if a mapped method doesn't exist or has an unsuitable signature.
+func (rc *RouterCenter) RegisterService(rcvr interface{}, cmds map[string]RouteKey) error {
+	v := reflect.ValueOf(rcvr)
+	all, err := serviceCmds(v.Type(), cmds)
+	if err != nil {
+		return err
+	}
+	methods := make(map[string]reflect.Method, len(all))
+	for name := range all {
+		method, ok := v.Type().MethodByName(name)
+		if !ok {
+			return fmt.Errorf("route: service %v has no method %v", v.Type(), name)
+		}
+		if err = checkMethod(method.Type); err != nil {
+			return fmt.Errorf("route: method %v.%v: %v", v.Type(), name, err)
+		}
+		methods[name] = method
+	}
+	rg := rc.GroupOf(pkts.KindHttp)
+	for name, method := range methods {
+		key := all[name]
+		prototype := reflect.New(method.Type.In(2).Elem()).Elem().Interface()
+		rg.handle(RouteKey{Kind: rg.kind, Cmd: key.Cmd, Version: key.Version, Codec: key.Codec},
+			prototype, Named(fmt.Sprintf("%v.%v", v.Type(), name), methodHandler(v.Method(method.Index))))
+	}
+	return nil
+}
+
+// serviceCmds returns the commands of the methods mapped by both the struct tags and the cmds.
+func serviceCmds(t reflect.Type, cmds map[string]RouteKey) (map[string]RouteKey, error) {
+	all := make(map[string]RouteKey)
+	st := t
+	if st.Kind() == reflect.Ptr {
+		st = st.Elem()
+	}
+	if st.Kind() == reflect.Struct {
+		for i := 0; i < st.NumField(); i++ {
+			tag, ok := st.Field(i).Tag.Lookup(serviceTag)
+			if !ok {
+				continue
+			}
+			for _, item := range strings.Split(tag, ",") {
+				kv := strings.SplitN(strings.TrimSpace(item), "=", 2)
+				if len(kv) != 2 {
+					return nil, fmt.Errorf("route: invalid tag item '%v' of service %v", item, t)
+				}
+				key, err := ParseMsgId(pkts.KindHttp, kv[1])
+				if err != nil {
+					return nil, fmt.Errorf("route: invalid tag item '%v' of service %v", item, t)
+				}
+				all[kv[0]] = key
+			}
+		}
+	}
+	for name, key := range cmds {
+		all[name] = key
+	}
+	return all, nil
+}
+
+// checkMethod checks whether the method type mt (with the receiver) is suitable.
+func checkMethod(mt reflect.Type) error {
+	if mt.NumIn() != 3 || mt.NumOut() != 2 {
+		return errors.New("wrong number of ins or outs")
+	}
+	if mt.In(1) != typeOfContext {
+		return errors.New("the first argument is not context.Context")
+	}
+	if mt.In(2).Kind() != reflect.Ptr || mt.Out(0).Kind() != reflect.Ptr {
+		return errors.New("the request or response is not a pointer")
+	}
+	if mt.Out(1) != typeOfError {
+		return errors.New("the second result is not error")
+	}
+	return nil
+}
+
+// methodHandler returns the handler to call the method m,
+// the c.Msg is the request allocated by the router.
+func methodHandler(m reflect.Value) HandlerFunc {
+	return func(c *Context) {
+		serve(c, c.Msg, func() (interface{}, error) {
+			outs := m.Call([]reflect.Value{reflect.ValueOf(c.Context()), reflect.ValueOf(c.Msg)})
+			err, _ := outs[1].Interface().(error)
+			if outs[0].IsNil() {
+				return nil, err
+			}
+			return outs[0].Interface(), err
+		})
+	}
+}
@@ -0,0 +1,71 @@
+package route
+
+import (
+	"github.com/happyxcj/gosocket/pkts"
+	"github.com/happyxcj/gosocket/protocol"
+)
+
+// StreamWriter sends a stream of responses for a request,
+// all responses share the sequence id of the request.
+//
+// Every response except the last one has the flag "FlagMore". If the request has
+// the property "PropWindow", a response takes a credit granted by the property
+// and the following WindowPkts, and Send waits if no credit is left.
+type StreamWriter struct {
+	c     *Context
+	ended bool
+}
+
+// ReplyStream returns a StreamWriter for the request packet.
+// It must be used before the handlers return, and the stream must be ended by End
+// or EndError. The handlers of a request with the flag "FlagStream" are run in
+// a new goroutine, so they can wait for the credits.
+func (c *Context) ReplyStream() (*StreamWriter, error) {
+	if c.inflight == nil {
+		return nil, ErrNotReqRespPkt
+	}
+	return &StreamWriter{c: c}, nil
+}
+
+// Send sends a response with the v encoded by the codec of the request packet.
+// It waits for a credit if needed, and returns an error if the context is done.
+func (w *StreamWriter) Send(v interface{}) error {
+	if w.ended {
+		return ErrStreamEnded
+	}
+	if err := w.c.inflight.acquire(w.c.Context()); err != nil {
+		return err
+	}
+	resp, err := w.c.newResp()
+	if err != nil {
+		return err
+	}
+	resp.(flagsAdder).AddFlags(pkts.FlagMore)
+	if err = pkts.EncodeBody(resp, v); err != nil {
+		return err
+	}
+	return w.c.Conn.Send(resp)
+}
+
+// End ends the stream by an empty response.
+func (w *StreamWriter) End() error {
+	if w.ended {
+		return ErrStreamEnded
+	}
+	w.ended = true
+	return w.c.Reply(nil)
+}
+
+// EndError ends the stream by a response with the Status converted from the err.
+func (w *StreamWriter) EndError(err error) error {
+	if w.ended {
+		return ErrStreamEnded
+	}
+	w.ended = true
+	return w.c.ReplyError(err)
+}
+
+// flagsAdder is implemented by the packets embedding a *protocol.PktBase.
+type flagsAdder interface {
+	AddFlags(flags ...protocol.PktFlags)
+}
@@ -0,0 +1,25 @@
+package route
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Timeout returns a middleware that sets the timeout of the context for the
+// subsequent handlers, it's usually used for a specified route.
+// If the context has an earlier deadline, such as the deadline of the request,
+// the earlier one is kept, so the d is the maximum time for handling the packet.
+//
+// The handlers are not interrupted when the timeout expires,
+// they should stop by watching the Done of the context.
+func Timeout(d time.Duration) HandlerFunc {
+	return Named(fmt.Sprintf("Timeout(%v)", d), func(c *Context) {
+		parent := c.ctx
+		ctx, cancel := context.WithTimeout(parent, d)
+		c.ctx = ctx
+		c.Next()
+		cancel()
+		c.ctx = parent
+	})
+}
@@ -0,0 +1,56 @@
+package route
+
+import (
+	"github.com/happyxcj/gosocket/pkts"
+)
+
+// TypedHandlerFunc handles a decoded request message and returns the response message.
+type TypedHandlerFunc[Req, Resp any] func(c *Context, req *Req) (*Resp, error)
+
+// HandleTyped registers the handler for the msgId in the rg.
+//
+// The application message is decoded into a Req by the codec of the packet and
+// validated before the handler is called, and c.Msg is set to the decoded *Req.
+// For a request, a response with the same sequence id is sent automatically,
+// it contains the encoded result of the handler or the Status converted from
+// the error of the handler. For the other packets, the result is discarded.
+func HandleTyped[Req, Resp any](rg *RouterGroup, msgId interface{}, handler TypedHandlerFunc[Req, Resp]) *RouterGroup {
+	var prototype Req
+	return rg.Handle(msgId, prototype, func(c *Context) {
+		req, ok := c.Msg.(*Req)
+		if !ok {
+			req = new(Req)
+			c.Msg = req
+		}
+		serve(c, req, func() (interface{}, error) {
+			resp, err := handler(c, req)
+			if resp == nil {
+				return nil, err
+			}
+			return resp, err
+		})
+	})
+}
+
+// serve decodes the application message into the req, and replies with the result
+// of the call for a request, which is the response message or an error.
+// The result is discarded for the other packets.
+func serve(c *Context, req interface{}, call func() (interface{}, error)) {
+	_, isReq := pkts.AsRequest(c.Pkt)
+	if err := c.Decode(req); err != nil {
+		if isReq {
+			c.ReplyStatus(BadRequest(err))
+		}
+		c.Abort()
+		return
+	}
+	resp, err := call()
+	if !isReq {
+		return
+	}
+	if err != nil {
+		c.ReplyError(err)
+		return
+	}
+	c.Reply(resp)
+}
@@ -0,0 +1,114 @@
+package route
+
+import (
+	"errors"
+
+	"github.com/happyxcj/gosocket/pkts"
+)
+
+// Validator is implemented by the messages that can validate themselves.
+type Validator interface {
+	// Validate returns an error if the message is invalid.
+	Validate() error
+}
+
+// ValidateFunc validates the decoded message msg of the route.
+type ValidateFunc func(c *Context, msg interface{}) error
+
+// Validate validates the v by its Validate method if it implements the Validator,
+// and then by the ValidateFunc of the route if it exists.
+//
+// The message of a route is validated by the router before the handlers run,
+// see bindMsg. It's also called by c.Decode for the other values.
+func (c *Context) Validate(v interface{}) error {
+	if validator, ok := v.(Validator); ok {
+		if err := validator.Validate(); err != nil {
+			return err
+		}
+	}
+	if c.validate != nil {
+		return c.validate(c, v)
+	}
+	return nil
+}
+
+// Validate sets the ValidateFunc for the route of the msgId,
+// it panics if the route has not been registered.
+func (rg *RouterGroup) Validate(msgId interface{}, fn ValidateFunc) *RouterGroup {
+	info, ok := rg.routes[rg.parseKey(msgId)]
+	if !ok {
+		panic("validate an unregistered route")
+	}
+	info.validate = fn
+	return rg
+}
+
+// bindHandler is the named bindMsg.
+var bindHandler = Named("Bind", bindMsg)
+
+// bindMsg decodes the application message into c.Msg and validates it, it's
+// the first handler of a route with a message, so it runs after the middlewares
+// such as Recovery. If it fails, the context is aborted, and a response with
+// the Status converted by BadRequest or InvalidArgument is sent if the packet
+// is a request.
+func bindMsg(c *Context) {
+	if !c.bind() {
+		c.Abort()
+	}
+}
+
+// bind decodes the application message into c.Msg and validates it,
+// c.Msg must not be nil. It returns false if it fails.
+func (c *Context) bind() bool {
+	pkt, ok := c.Pkt.(pkts.DataPkt)
+	if !ok {
+		return true
+	}
+	var st *pkts.Status
+	if err := pkts.DecodeBody(pkt, c.Msg); err != nil {
+		st = BadRequest(err)
+	} else if err = c.Validate(c.Msg); err != nil {
+		st = InvalidArgument(err)
+	} else {
+		c.bound = c.Msg
+		return true
+	}
+	c.replyRequest(st)
+	return false
+}
+
+// Bind decodes the application message into c.Msg and validates it.
+// If it fails, the context is aborted, and a response with the Status converted by
+// BadRequest is sent if the packet is a request, the other packets are dropped.
+// It does nothing if the route has no message or c.Msg has been decoded by the router.
+func Bind(c *Context) {
+	if c.Msg == nil {
+		return
+	}
+	if err := c.Decode(c.Msg); err != nil {
+		c.Abort()
+		c.replyRequest(BadRequest(err))
+	}
+}
+
+// BadRequest converts the err of decoding or validating a message to a Status.
+// It returns the err itself if the err is a Status, otherwise
+// a Status with the code "StatusBadRequest" and the message of the err.
+func BadRequest(err error) *pkts.Status {
+	var st *pkts.Status
+	if errors.As(err, &st) {
+		return st
+	}
+	return pkts.NewStatus(pkts.StatusBadRequest, err.Error())
+}
+
+// InvalidArgument converts the err of validating a message to a Status.
+// It returns the err itself if the err is a Status, otherwise
+// a Status with the code "StatusInvalidArgument" and the message of the err.
+func InvalidArgument(err error) *pkts.Status {
+	var st *pkts.Status
+	if errors.As(err, &st) {
+		return st
+	}
+	return pkts.NewStatus(pkts.StatusInvalidArgument, err.Error())
+}
@@ -0,0 +1,90 @@
+package route
+
+import (
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/happyxcj/gosocket/pkts"
+	"github.com/happyxcj/gosocket/protocol"
+)
+
+// recordConn records the sent packets.
+type recordConn struct {
+	mu   sync.Mutex
+	sent []protocol.Packet
+}
+
+func (c *recordConn) Send(p protocol.Packet) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sent = append(c.sent, p)
+	return nil
+}
+
+func (c *recordConn) Receive() (protocol.Packet, error) { select {} }
+func (c *recordConn) Close() error                      { return nil }
+
+// status returns the status of the only sent packet.
+func (c *recordConn) status(t *testing.T) uint16 {
+	t.Helper()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.sent) != 1 {
+		t.Fatalf("expect 1 sent packet, got %d", len(c.sent))
+	}
+	st, ok := pkts.GetStatus(c.sent[0].(pkts.DataPkt))
+	if !ok {
+		return pkts.StatusOK
+	}
+	return st.Code
+}
+
+type testMsg struct{ N int }
+
+func (m *testMsg) Validate() error {
+	if m.N < 0 {
+		return errors.New("negative")
+	}
+	if m.N == 0 {
+		panic("zero")
+	}
+	return nil
+}
+
+func newTestRouter(called *int) *RouterCenter {
+	rc := NewRouterCenter()
+	rc.Use(Recovery(RecoveryHandler(func(c *Context, err *PanicError) {
+		c.ReplyStatus(pkts.NewStatus(pkts.StatusInternal, ""))
+	})))
+	rc.Group(pkts.KindHttp).Handle(1, testMsg{}, func(c *Context) {
+		*called++
+		c.Reply(nil)
+	})
+	return rc
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		body string
+		code uint16
+	}{
+		{`{"N":1}`, pkts.StatusOK},
+		{`{"N":-1}`, pkts.StatusInvalidArgument},
+		{`{`, pkts.StatusBadRequest},
+		// The panic of the validation is recovered by Recovery.
+		{`{"N":0}`, pkts.StatusInternal},
+	}
+	for _, tt := range tests {
+		called := 0
+		rc := newTestRouter(&called)
+		c := &recordConn{}
+		rc.HandlePacket(c, pkts.NewEasyHttpPkt(1, 1, []byte(tt.body)))
+		if code := c.status(t); code != tt.code {
+			t.Errorf("%s: expect status %d, got %d", tt.body, tt.code, code)
+		}
+		if want := tt.code == pkts.StatusOK; (called == 1) != want {
+			t.Errorf("%s: the handler is called %d times", tt.body, called)
+		}
+	}
+}
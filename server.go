@@ -0,0 +1,537 @@
+package gosocket
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/happyxcj/gosocket/pkts"
+	"github.com/happyxcj/gosocket/protocol"
+)
+
+// ErrServerClosed is returned by the Server's Serve method after a call to Close or Shutdown.
+var ErrServerClosed = errors.New("server closed")
+
+const (
+	// shutdownPollInterval is the interval to check whether the in-flight requests
+	// finish during Shutdown.
+	shutdownPollInterval = 10 * time.Millisecond
+	// minAcceptDelay and maxAcceptDelay bound the delay to retry accepting
+	// the connections after an error.
+	minAcceptDelay = 5 * time.Millisecond
+	maxAcceptDelay = time.Second
+)
+
+// Server accepts the incoming connections and keeps track of them.
+type Server struct {
+	opts *ServerOpts
+
+	mu        sync.Mutex
+	closed    bool
+	listeners map[net.Listener]struct{}
+	conns     map[uint64]*QueueConn
+	// muxes contains the stream multiplexers keyed by the connection id.
+	muxes map[uint64]*Mux
+
+	groups   *Groups
+	presence *Presence
+
+	// jobs delivers the packets to the worker goroutines if they are enabled.
+	jobs chan func()
+	// quit is closed to stop the worker goroutines when the server is closed.
+	quit chan struct{}
+
+	// drainingFlag indicates whether the server is shutting down, see Shutdown.
+	drainingFlag uint32
+	// active is the number of the packets being handled or waiting for the workers.
+	active int64
+}
+
+type ServerOpts struct {
+	ecOpts       []EasyConnOpt
+	qcOpts       []QueueConnOpt
+	presenceOpts []PresenceOpt
+	muxOpts      []MuxOpt
+	// router routes the every packet received from the connections.
+	router Router
+	// pktHandler handles the every packet received from the connections,
+	// it's called only if the packet can't be handled by the router.
+	pktHandler func(c *QueueConn, p protocol.Packet)
+	// onConnect is the callback when a new connection is accepted.
+	onConnect func(c *QueueConn)
+	// onDisconnect is the callback when a connection is closed.
+	onDisconnect func(c *QueueConn, cause error)
+	// onAcceptError is the callback when the listener fails to accept a connection.
+	onAcceptError func(err error)
+	// workers is the number of the goroutines to handle the packets concurrently.
+	// A zero value of it means the packets of a connection are handled
+	// in its receiving goroutine one by one.
+	workers int
+	// replyUnknown indicates whether to reply to the requests handled by neither
+	// the router nor the pktHandler with the status "StatusNotFound".
+	// It's default value is true.
+	replyUnknown bool
+}
+
+// ServerOpt specifies an option for a server.
+type ServerOpt func(*ServerOpts)
+
+// ServerECOptions returns a ServerOpt to add options to the internal ecOpts.
+func ServerECOptions(opts ...EasyConnOpt) ServerOpt {
+	return func(o *ServerOpts) {
+		o.ecOpts = append(o.ecOpts, opts...)
+	}
+}
+
+// ServerQCOptions returns a ServerOpt to add options to the internal qcOpts.
+func ServerQCOptions(opts ...QueueConnOpt) ServerOpt {
+	return func(o *ServerOpts) {
+		o.qcOpts = append(o.qcOpts, opts...)
+	}
+}
+
+// ServerPresenceOptions returns a ServerOpt to add options to the internal presenceOpts.
+func ServerPresenceOptions(opts ...PresenceOpt) ServerOpt {
+	return func(o *ServerOpts) {
+		o.presenceOpts = append(o.presenceOpts, opts...)
+	}
+}
+
+// ServerMuxOptions returns a ServerOpt to add options to the internal muxOpts.
+func ServerMuxOptions(opts ...MuxOpt) ServerOpt {
+	return func(o *ServerOpts) {
+		o.muxOpts = append(o.muxOpts, opts...)
+	}
+}
+
+// ServerRouter returns a ServerOpt to set the router for all connections.
+func ServerRouter(router Router) ServerOpt {
+	return func(o *ServerOpts) {
+		o.router = router
+	}
+}
+
+// ServerPktHandler returns a ServerOpt to set the packet handler for all connections.
+// If a router is set, it only handles the packets that can't be handled by the router.
+func ServerPktHandler(handler func(*QueueConn, protocol.Packet)) ServerOpt {
+	return func(o *ServerOpts) {
+		o.pktHandler = handler
+	}
+}
+
+// OnConnect returns a ServerOpt to set the callback when a new connection is accepted.
+func OnConnect(onConnect func(*QueueConn)) ServerOpt {
+	return func(o *ServerOpts) {
+		o.onConnect = onConnect
+	}
+}
+
+// OnDisconnect returns a ServerOpt to set the callback when a connection is closed.
+func OnDisconnect(onDisconnect func(*QueueConn, error)) ServerOpt {
+	return func(o *ServerOpts) {
+		o.onDisconnect = onDisconnect
+	}
+}
+
+// OnAcceptError returns a ServerOpt to set the callback when the listener fails to
+// accept a connection, the server retries accepting after a delay unless the listener is closed.
+func OnAcceptError(onAcceptError func(error)) ServerOpt {
+	return func(o *ServerOpts) {
+		o.onAcceptError = onAcceptError
+	}
+}
+
+// ServerReplyUnknown returns a ServerOpt to set whether to reply to the requests
+// handled by neither the router nor the packet handler with the status "StatusNotFound",
+// so the clients don't wait for them until timeout. The responses are never replied.
+// It's enabled by default, use ServerReplyUnknown(false) to drop them silently.
+func ServerReplyUnknown(reply bool) ServerOpt {
+	return func(o *ServerOpts) {
+		o.replyUnknown = reply
+	}
+}
+
+// ServerWorkers returns a ServerOpt to set the number of the goroutines shared by
+// all connections to handle the packets concurrently.
+//
+// The packets of a connection may be handled concurrently and out of order,
+// except that the CancelPkt, WindowPkt and StreamPkt are always handled in the
+// receiving goroutine, so the in-flight requests can be cancelled or granted in time.
+// The receiving goroutine blocks if all workers are busy.
+func ServerWorkers(n int) ServerOpt {
+	return func(o *ServerOpts) {
+		o.workers = n
+	}
+}
+
+func NewServer(opts ...ServerOpt) *Server {
+	s := &Server{
+		opts:      &ServerOpts{replyUnknown: true},
+		listeners: make(map[net.Listener]struct{}),
+		conns:     make(map[uint64]*QueueConn),
+		muxes:     make(map[uint64]*Mux),
+		groups:    NewGroups(),
+		quit:      make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(s.opts)
+	}
+	s.presence = NewPresence(s.opts.presenceOpts...)
+	if s.opts.workers > 0 {
+		s.jobs = make(chan func())
+		for i := 0; i < s.opts.workers; i++ {
+			go s.work()
+		}
+	}
+	return s
+}
+
+// Groups returns the group registry of the server,
+// the closed connections will be removed from all groups automatically.
+func (s *Server) Groups() *Groups {
+	return s.groups
+}
+
+// Presence returns the presence registry of the server,
+// the closed connections will be unbound from their users automatically.
+func (s *Server) Presence() *Presence {
+	return s.presence
+}
+
+// ListenAndServe listens on the TCP network address and then calls Serve.
+func (s *Server) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// Serve accepts the incoming connections on the l until the server is closed.
+// The l will be closed when it returns.
+//
+// The failures of accepting are reported by the callback set by OnAcceptError,
+// and retried with an increasing delay until the l is closed.
+func (s *Server) Serve(l net.Listener) error {
+	if !s.trackListener(l) {
+		l.Close()
+		return ErrServerClosed
+	}
+	defer func() {
+		s.mu.Lock()
+		delete(s.listeners, l)
+		s.mu.Unlock()
+		l.Close()
+	}()
+	var delay time.Duration
+	for {
+		nc, err := l.Accept()
+		if err != nil {
+			if s.isClosed() {
+				return ErrServerClosed
+			}
+			if errors.Is(err, net.ErrClosed) {
+				return err
+			}
+			if s.opts.onAcceptError != nil {
+				s.opts.onAcceptError(err)
+			}
+			if delay *= 2; delay == 0 {
+				delay = minAcceptDelay
+			} else if delay > maxAcceptDelay {
+				delay = maxAcceptDelay
+			}
+			time.Sleep(delay)
+			continue
+		}
+		delay = 0
+		s.serveConn(nc)
+	}
+}
+
+func (s *Server) serveConn(nc net.Conn) {
+	c := newQueueConn(NewEasyConn(nc, s.opts.ecOpts...), s.opts.qcOpts...)
+	router, pktHandler := s.opts.router, s.opts.pktHandler
+	if pktHandler == nil && s.opts.replyUnknown {
+		pktHandler = s.replyUnknown
+	}
+	switch {
+	case router != nil && pktHandler != nil:
+		c.pktHandler = func(p protocol.Packet) {
+			if !router.HandlePacket(c, p) {
+				pktHandler(c, p)
+			}
+		}
+	case router != nil:
+		c.pktHandler = func(p protocol.Packet) {
+			router.HandlePacket(c, p)
+		}
+	case pktHandler != nil:
+		c.pktHandler = func(p protocol.Packet) {
+			pktHandler(c, p)
+		}
+	}
+	handle := c.pktHandler
+	if s.jobs != nil {
+		// The packets are consumed when the workers finish handling them,
+		// see RecvWindow.
+		c.consumeAsync = true
+	}
+	c.pktHandler = func(p protocol.Packet) {
+		if s.isDraining() && flowControlled(p) {
+			s.reject(c, p)
+			if c.consumeAsync {
+				c.consume(p)
+			}
+			return
+		}
+		atomic.AddInt64(&s.active, 1)
+		if s.jobs == nil || p.Kind() == pkts.KindCancel || p.Kind() == pkts.KindWindow {
+			handle(p)
+			atomic.AddInt64(&s.active, -1)
+			return
+		}
+		select {
+		case s.jobs <- func() {
+			handle(p)
+			c.consume(p)
+			atomic.AddInt64(&s.active, -1)
+		}:
+		case <-s.quit:
+			atomic.AddInt64(&s.active, -1)
+		}
+	}
+	// The StreamPkts are always handled in the receiving goroutine to keep their order.
+	m := NewMux(c, false, s.opts.muxOpts...)
+	next := c.pktHandler
+	c.pktHandler = func(p protocol.Packet) {
+		if !m.HandlePacket(c, p) {
+			next(p)
+		}
+	}
+	onClose := c.onClose
+	c.onClose = func(cause error) {
+		s.untrackConn(c)
+		m.Close()
+		if onClose != nil {
+			onClose(cause)
+		}
+		if s.opts.onDisconnect != nil {
+			s.opts.onDisconnect(c, cause)
+		}
+	}
+	if !s.trackConn(c, m) {
+		nc.Close()
+		return
+	}
+	if s.opts.onConnect != nil {
+		s.opts.onConnect(c)
+	}
+	c.start()
+}
+
+// work handles the packets delivered by the jobs until the server is closed.
+func (s *Server) work() {
+	for {
+		select {
+		case job := <-s.jobs:
+			job()
+		case <-s.quit:
+			return
+		}
+	}
+}
+
+// Conn returns the connection for the given id if it exists.
+func (s *Server) Conn(id uint64) (*QueueConn, bool) {
+	s.mu.Lock()
+	c, ok := s.conns[id]
+	s.mu.Unlock()
+	return c, ok
+}
+
+// Mux returns the stream multiplexer of the connection for the given id if it exists,
+// the streams opened by the client are accepted by it.
+func (s *Server) Mux(id uint64) (*Mux, bool) {
+	s.mu.Lock()
+	m, ok := s.muxes[id]
+	s.mu.Unlock()
+	return m, ok
+}
+
+// Conns returns all connections of the server.
+func (s *Server) Conns() []*QueueConn {
+	s.mu.Lock()
+	conns := make([]*QueueConn, 0, len(s.conns))
+	for _, c := range s.conns {
+		conns = append(conns, c)
+	}
+	s.mu.Unlock()
+	return conns
+}
+
+// ConnNum returns the number of the connections of the server.
+func (s *Server) ConnNum() int {
+	s.mu.Lock()
+	n := len(s.conns)
+	s.mu.Unlock()
+	return n
+}
+
+// Close closes all listeners and then closes all connections
+// after their pending packets are sent.
+func (s *Server) Close() error {
+	err := s.closeListeners()
+	s.mu.Lock()
+	select {
+	case <-s.quit:
+	default:
+		close(s.quit)
+	}
+	s.mu.Unlock()
+	for _, c := range s.Conns() {
+		c.Close()
+	}
+	return err
+}
+
+// Shutdown gracefully shuts down the server for rolling deploys. It closes all listeners,
+// sends a GoAwayPkt with the reason and the optional redirect address to all connections,
+// and then waits for the in-flight requests to finish before closing the connections.
+//
+// The new requests received during the shutdown are rejected with the status
+// "StatusUnavailable", and the other new packets except the control ones are dropped,
+// including the responses carrying a status, so the peers never reply to each other's
+// error responses.
+// The requests handled asynchronously by the router are also waited for
+// if the router implements Drainer.
+//
+// If the ctx is done before the in-flight requests finish, the connections are closed
+// anyway and the error of the ctx is returned.
+func (s *Server) Shutdown(ctx context.Context, reason, redirect string) error {
+	atomic.StoreUint32(&s.drainingFlag, 1)
+	err := s.closeListeners()
+	goAway := pkts.NewGoAwayPkt(reason, redirect)
+	for _, c := range s.Conns() {
+		c.Send(goAway)
+	}
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+	for !s.isIdle() {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			s.Close()
+			return ctx.Err()
+		}
+	}
+	if e := s.Close(); err == nil {
+		err = e
+	}
+	return err
+}
+
+// closeListeners marks the server as closed and closes all listeners.
+func (s *Server) closeListeners() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	var err error
+	for l := range s.listeners {
+		if e := l.Close(); err == nil {
+			err = e
+		}
+		delete(s.listeners, l)
+	}
+	return err
+}
+
+func (s *Server) isDraining() bool {
+	return atomic.LoadUint32(&s.drainingFlag) != 0
+}
+
+// isIdle reports whether there is no packet being handled.
+func (s *Server) isIdle() bool {
+	if atomic.LoadInt64(&s.active) > 0 {
+		return false
+	}
+	d, ok := s.opts.router.(Drainer)
+	if !ok {
+		return true
+	}
+	for _, c := range s.Conns() {
+		if d.Inflight(c) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// replyUnknown replies to the request p not handled with the status "StatusNotFound".
+func (s *Server) replyUnknown(c *QueueConn, p protocol.Packet) {
+	req, ok := pkts.AsRequest(p)
+	if !ok {
+		return
+	}
+	resp, err := pkts.NewRespPkt(req)
+	if err != nil {
+		return
+	}
+	pkts.SetStatus(resp, pkts.Errorf(pkts.StatusNotFound, "unknown command: %v", req.Cmd()))
+	c.Send(resp)
+}
+
+// reject rejects the p received during the shutdown.
+// Only the requests are replied, the responses carrying a status are dropped.
+func (s *Server) reject(c *QueueConn, p protocol.Packet) {
+	req, ok := pkts.AsRequest(p)
+	if !ok {
+		return
+	}
+	resp, err := pkts.NewRespPkt(req)
+	if err != nil {
+		return
+	}
+	pkts.SetStatus(resp, pkts.NewStatus(pkts.StatusUnavailable, "the server is shutting down"))
+	c.Send(resp)
+}
+
+func (s *Server) isClosed() bool {
+	s.mu.Lock()
+	closed := s.closed
+	s.mu.Unlock()
+	return closed
+}
+
+func (s *Server) trackListener(l net.Listener) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return false
+	}
+	s.listeners[l] = struct{}{}
+	return true
+}
+
+func (s *Server) trackConn(c *QueueConn, m *Mux) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return false
+	}
+	s.conns[c.Id()] = c
+	s.muxes[c.Id()] = m
+	return true
+}
+
+func (s *Server) untrackConn(c *QueueConn) {
+	s.mu.Lock()
+	delete(s.conns, c.Id())
+	delete(s.muxes, c.Id())
+	s.mu.Unlock()
+	s.groups.LeaveAll(c)
+	s.presence.Unbind(c)
+}
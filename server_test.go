@@ -0,0 +1,81 @@
+package gosocket
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/happyxcj/gosocket/pkts"
+	"github.com/happyxcj/gosocket/protocol"
+)
+
+// routerFunc is a Router handles the packets by the function.
+type routerFunc func(c Conn, p protocol.Packet) bool
+
+func (f routerFunc) HandlePacket(c Conn, p protocol.Packet) bool {
+	return f(c, p)
+}
+
+func startServer(t *testing.T, opts ...ServerOpt) (*Server, string) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewServer(opts...)
+	go s.Serve(l)
+	t.Cleanup(func() { s.Close() })
+	return s, l.Addr().String()
+}
+
+// failListener is a net.Listener that fails to accept the connections n times,
+// and then returns net.ErrClosed.
+type failListener struct {
+	net.Listener
+	n int
+}
+
+var errAccept = errors.New("accept failed")
+
+func (l *failListener) Accept() (net.Conn, error) {
+	if l.n > 0 {
+		l.n--
+		return nil, errAccept
+	}
+	return nil, net.ErrClosed
+}
+
+func (l *failListener) Close() error { return nil }
+
+func TestServeAcceptError(t *testing.T) {
+	var errs []error
+	s := NewServer(OnAcceptError(func(err error) { errs = append(errs, err) }))
+	defer s.Close()
+	if err := s.Serve(&failListener{n: 2}); !errors.Is(err, net.ErrClosed) {
+		t.Fatalf("expect %v, got %v", net.ErrClosed, err)
+	}
+	if len(errs) != 2 || errs[0] != errAccept {
+		t.Fatalf("expect 2 accept errors, got %v", errs)
+	}
+}
+
+func TestServerReplyUnknown(t *testing.T) {
+	ignore := ServerRouter(routerFunc(func(c Conn, p protocol.Packet) bool { return false }))
+	_, addr := startServer(t, ignore)
+	c := NewClient(addr)
+	defer c.Close()
+	_, err := c.Http(context.Background(), pkts.NewEasyHttpPkt(1, 7, nil))
+	if st, ok := err.(*pkts.Status); !ok || st.Code != pkts.StatusNotFound {
+		t.Fatalf("expect status %d, got %v", pkts.StatusNotFound, err)
+	}
+
+	_, addr = startServer(t, ignore, ServerReplyUnknown(false))
+	c = NewClient(addr)
+	defer c.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err = c.Http(ctx, pkts.NewEasyHttpPkt(1, 7, nil)); err != ErrTimeout {
+		t.Fatalf("expect %v, got %v", ErrTimeout, err)
+	}
+}